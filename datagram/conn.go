@@ -0,0 +1,161 @@
+package datagram
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+// Virtual connection to a target, every Write sends a datagram and every Read returns one datagram
+// received from the target. The datagrams received are passed to it with Deliver.
+type Conn struct {
+	local  net.Addr
+	target netip.AddrPort
+	// Sends a single datagram to the target
+	send func(b []byte) (int, error)
+	// Called once by Close, can be nil
+	release func()
+	queue   chan []byte
+
+	mu            sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+	// Closed by Close, wakes up Read
+	closed    chan struct{}
+	closeOnce sync.Once
+	// Closed by Fail, Read and Write return err from then on
+	failed   chan struct{}
+	failOnce sync.Once
+	err      error
+}
+
+// Connection to target sending its datagrams with send, release is called when it is closed
+func NewConn(local net.Addr, target netip.AddrPort, send func(b []byte) (int, error), release func()) *Conn {
+	return &Conn{
+		local:   local,
+		target:  target,
+		send:    send,
+		release: release,
+		queue:   make(chan []byte, QUEUE_LENGTH),
+		closed:  make(chan struct{}),
+		failed:  make(chan struct{}),
+	}
+}
+
+// Queues a datagram received from the target for Read, it is dropped when the queue is full.
+// The datagram is not copied.
+func (c *Conn) Deliver(datagram []byte) {
+	select {
+	case c.queue <- datagram:
+	default:
+	}
+}
+
+// Makes the pending and next calls of Read and Write return err, the datagrams still queued are dropped.
+// Used when the socket of the connection can't be read anymore.
+func (c *Conn) Fail(err error) {
+	c.failOnce.Do(func() {
+		c.err = err
+		close(c.failed)
+	})
+}
+
+// Returns the next datagram from the target, the rest of a datagram longer than b is discarded
+func (c *Conn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	deadline := c.readDeadline
+	c.mu.Unlock()
+
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case datagram := <-c.queue:
+		return copy(b, datagram), nil
+	case <-expired:
+		return 0, os.ErrDeadlineExceeded
+	case <-c.closed:
+		return 0, net.ErrClosed
+	case <-c.failed:
+		return 0, c.err
+	}
+}
+
+// Sends b to the target as a single datagram
+func (c *Conn) Write(b []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	case <-c.failed:
+		return 0, c.err
+	default:
+	}
+
+	c.mu.Lock()
+	deadline := c.writeDeadline
+	c.mu.Unlock()
+
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	return c.send(b)
+}
+
+// Stops receiving datagrams from the target
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+
+		if c.release != nil {
+			c.release()
+		}
+	})
+
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return net.UDPAddrFromAddrPort(c.target)
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readDeadline = t
+	c.writeDeadline = t
+	return nil
+}
+
+// Only applies to the next calls of Read
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readDeadline = t
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeDeadline = t
+	return nil
+}
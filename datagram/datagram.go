@@ -0,0 +1,303 @@
+package datagram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// Datagrams received for a connection and not read yet, the next ones are dropped
+	QUEUE_LENGTH = 8
+	// Largest datagram received, longer ones are truncated
+	MAX_DATAGRAM_SIZE = 65535
+	// Size of the receive buffer of each socket, datagrams arriving while it is full are lost
+	READ_BUFFER_SIZE = 4 * 1024 * 1024
+
+	// Wait after a temporary error reading a socket, doubled on every error in a row up to MAX_READ_BACKOFF
+	READ_BACKOFF     = 5 * time.Millisecond
+	MAX_READ_BACKOFF = time.Second
+)
+
+// Sends datagrams to every target from a small pool of unconnected sockets, instead of a socket per target.
+//
+// Every target scanned gets a Conn bound to one of the sockets, the datagrams received by a socket are passed
+// to the Conn of the address they came from. A target is only scanned once at a time on each socket, a
+// second Dial to it waits until a socket is free.
+type Mux struct {
+	sockets []*net.UDPConn
+	// Address each socket is bound to, invalid if it can send to both families
+	sources []netip.Addr
+	// Socket the next Dial starts looking from
+	next atomic.Uint64
+
+	mu    sync.Mutex
+	conns []map[netip.AddrPort]*Conn
+	// Error that stopped each socket, nil while it can be used
+	failed []error
+	// Closed and replaced every time a Conn is closed, wakes up the Dials waiting for a socket
+	released chan struct{}
+	closed   bool
+	// Closed by Close, interrupts the backoff of the reads
+	done chan struct{}
+
+	wg sync.WaitGroup
+}
+
+// Sockets of a Mux
+type Config struct {
+	// Amount of sockets, at least one is opened for each address of Sources
+	Sockets int
+	// Local addresses the sockets are bound to, spread between them. A target is only sent to from the
+	// sockets of its family. Default: the sockets are dual-stack when IPv6 is available
+	Sources []netip.Addr
+	// Called on every socket before it is bound, see net.ListenConfig
+	Control func(network string, address string, c syscall.RawConn) error
+}
+
+// Opens the sockets of the config on random ports
+func Listen(config Config) (*Mux, error) {
+	count := max(config.Sockets, len(config.Sources))
+	if count < 1 {
+		return nil, errors.New("at least one socket is needed")
+	}
+
+	m := &Mux{released: make(chan struct{}), done: make(chan struct{})}
+	listener := net.ListenConfig{Control: config.Control}
+
+	for i := range count {
+		var source netip.Addr
+		if len(config.Sources) > 0 {
+			source = config.Sources[i%len(config.Sources)].Unmap()
+		}
+
+		address := ":0"
+		if source.IsValid() {
+			address = netip.AddrPortFrom(source, 0).String()
+		}
+
+		conn, err := listener.ListenPacket(context.Background(), "udp", address)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+
+		socket := conn.(*net.UDPConn)
+
+		// Best effort, the default buffer only holds a few datagrams
+		socket.SetReadBuffer(READ_BUFFER_SIZE)
+
+		m.sockets = append(m.sockets, socket)
+		m.sources = append(m.sources, source)
+		m.conns = append(m.conns, make(map[netip.AddrPort]*Conn))
+		m.failed = append(m.failed, nil)
+	}
+
+	for i := range m.sockets {
+		m.wg.Add(1)
+		go m.read(i)
+	}
+
+	return m, nil
+}
+
+// Returns a connection to the target over one of the sockets of its family, waits while the target is
+// scanned on every one of them. Sockets that failed are skipped.
+func (m *Mux) Dial(ctx context.Context, target netip.AddrPort) (*Conn, error) {
+	target = netip.AddrPortFrom(target.Addr().Unmap(), target.Port())
+	start := m.next.Add(1)
+
+	if !slices.ContainsFunc(m.sources, func(source netip.Addr) bool { return reaches(source, target.Addr()) }) {
+		return nil, fmt.Errorf("no socket can send to %s", target.Addr())
+	}
+
+	for {
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			return nil, net.ErrClosed
+		}
+
+		var failed error
+		usable := false
+
+		for i := range m.sockets {
+			socket := int((start + uint64(i)) % uint64(len(m.sockets)))
+			if !reaches(m.sources[socket], target.Addr()) {
+				continue
+			}
+
+			if m.failed[socket] != nil {
+				failed = m.failed[socket]
+				continue
+			}
+
+			usable = true
+
+			if _, busy := m.conns[socket][target]; busy {
+				continue
+			}
+
+			conn := m.newConn(socket, target)
+			m.conns[socket][target] = conn
+			m.mu.Unlock()
+			return conn, nil
+		}
+
+		released := m.released
+		m.mu.Unlock()
+
+		if !usable {
+			return nil, fmt.Errorf("no socket left to send to %s: %s", target.Addr(), failed)
+		}
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Reports whether a socket bound to source can send to target
+func reaches(source netip.Addr, target netip.Addr) bool {
+	return !source.IsValid() || source.Is4() == target.Is4()
+}
+
+// Closes every socket, the connections still open stop receiving
+func (m *Mux) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+
+	m.closed = true
+	close(m.released)
+	close(m.done)
+	m.mu.Unlock()
+
+	var err error
+	for _, socket := range m.sockets {
+		err = errors.Join(err, socket.Close())
+	}
+
+	m.wg.Wait()
+	return err
+}
+
+// Receives the datagrams of a socket and queues them for the connection of their source, until the socket is closed
+// or fails
+func (m *Mux) read(socket int) {
+	defer m.wg.Done()
+
+	buf := make([]byte, MAX_DATAGRAM_SIZE)
+	backoff := READ_BACKOFF
+
+	for {
+		n, from, err := m.sockets[socket].ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			if !temporary(err) {
+				m.fail(socket, err)
+				return
+			}
+
+			select {
+			case <-time.After(backoff):
+			case <-m.done:
+				return
+			}
+
+			backoff = min(backoff*2, MAX_READ_BACKOFF)
+			continue
+		}
+
+		backoff = READ_BACKOFF
+		from = netip.AddrPortFrom(from.Addr().Unmap(), from.Port())
+
+		m.mu.Lock()
+		conn := m.conns[socket][from]
+		m.mu.Unlock()
+
+		// Nothing is waiting for it, an answer that arrived too late or a stray datagram
+		if conn == nil {
+			continue
+		}
+
+		conn.Deliver(append([]byte(nil), buf[:n]...))
+	}
+}
+
+// Reports whether reading the socket can be tried again after err, like running out of buffers or an ICMP error
+// reported on the socket
+func temporary(err error) bool {
+	var timeout interface{ Timeout() bool }
+	if errors.As(err, &timeout) && timeout.Timeout() {
+		return true
+	}
+
+	for _, errno := range []syscall.Errno{syscall.EINTR, syscall.EAGAIN, syscall.ENOBUFS, syscall.ENOMEM, syscall.ECONNREFUSED, syscall.ECONNRESET} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Stops using a socket that can't be read anymore, its connections fail and the next Dials skip it
+func (m *Mux) fail(socket int, err error) {
+	err = fmt.Errorf("failed to read socket %s: %s", m.sockets[socket].LocalAddr(), err)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.failed[socket] = err
+
+	for _, conn := range m.conns[socket] {
+		conn.Fail(err)
+	}
+
+	// The Dials waiting for the socket look for another one
+	if !m.closed {
+		close(m.released)
+		m.released = make(chan struct{})
+	}
+}
+
+// Connection to the target over the socket, every Write sends a datagram from it
+func (m *Mux) newConn(socket int, target netip.AddrPort) *Conn {
+	send := func(b []byte) (int, error) {
+		return m.sockets[socket].WriteToUDPAddrPort(b, target)
+	}
+
+	var conn *Conn
+	conn = NewConn(m.sockets[socket].LocalAddr(), target, send, func() { m.release(socket, target, conn) })
+	return conn
+}
+
+// Removes the connection, its target can be dialed again on the socket
+func (m *Mux) release(socket int, target netip.AddrPort, conn *Conn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.conns[socket][target] == conn {
+		delete(m.conns[socket], target)
+	}
+
+	if !m.closed {
+		close(m.released)
+		m.released = make(chan struct{})
+	}
+}
@@ -0,0 +1,296 @@
+package datagram
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Answers every datagram with prefix followed by the datagram
+func listenEcho(tb testing.TB, prefix string) netip.AddrPort {
+	tb.Helper()
+
+	conn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.MustParseAddrPort("127.0.0.1:0")))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	tb.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+
+		for {
+			n, from, err := conn.ReadFromUDPAddrPort(buf)
+			if err != nil {
+				return
+			}
+
+			conn.WriteToUDPAddrPort(append([]byte(prefix), buf[:n]...), from)
+		}
+	}()
+
+	return netip.MustParseAddrPort(conn.LocalAddr().String())
+}
+
+func listen(tb testing.TB, count int) *Mux {
+	tb.Helper()
+
+	m, err := Listen(Config{Sockets: count})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	tb.Cleanup(func() { m.Close() })
+	return m
+}
+
+func exchange(tb testing.TB, conn net.Conn, request string) string {
+	tb.Helper()
+
+	_, err := conn.Write([]byte(request))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return string(buf[:n])
+}
+
+func TestMuxDemultiplexes(t *testing.T) {
+	first := listenEcho(t, "first ")
+	second := listenEcho(t, "second ")
+	m := listen(t, 1)
+
+	a, err := m.Dial(context.Background(), first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := m.Dial(context.Background(), second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both use the only socket
+	if a.LocalAddr().String() != b.LocalAddr().String() {
+		t.Fatalf("expected a single socket, got %s and %s", a.LocalAddr(), b.LocalAddr())
+	}
+
+	for range 3 {
+		if answer := exchange(t, b, "b"); answer != "second b" {
+			t.Fatalf("unexpected answer %q", answer)
+		}
+
+		if answer := exchange(t, a, "a"); answer != "first a" {
+			t.Fatalf("unexpected answer %q", answer)
+		}
+	}
+}
+
+func TestConnDeadline(t *testing.T) {
+	m := listen(t, 1)
+
+	// Nothing listens on it
+	silent, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.MustParseAddrPort("127.0.0.1:0")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer silent.Close()
+
+	conn, err := m.Dial(context.Background(), netip.MustParseAddrPort(silent.LocalAddr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Write([]byte("hello"))
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+
+	start := time.Now()
+	_, err = conn.Read(make([]byte, 16))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("read returned after %s, before the deadline", elapsed)
+	}
+
+	// Closing unblocks a read without a deadline
+	conn.SetReadDeadline(time.Time{})
+	time.AfterFunc(50*time.Millisecond, func() { conn.Close() })
+
+	_, err = conn.Read(make([]byte, 16))
+	if !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+}
+
+func TestDialWaitsForFreeSocket(t *testing.T) {
+	target := listenEcho(t, "")
+	m := listen(t, 2)
+
+	first, err := m.Dial(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := m.Dial(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Answers of the same host can't be told apart on a socket
+	if first.LocalAddr().String() == second.LocalAddr().String() {
+		t.Fatalf("expected the target to use both sockets, got %s twice", first.LocalAddr())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = m.Dial(ctx, target)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the dial to wait for a free socket, got %v", err)
+	}
+
+	time.AfterFunc(50*time.Millisecond, func() { first.Close() })
+
+	third, err := m.Dial(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if third.LocalAddr().String() != first.LocalAddr().String() {
+		t.Fatalf("expected the socket released by the first connection, got %s", third.LocalAddr())
+	}
+
+	if answer := exchange(t, third, "hello"); answer != "hello" {
+		t.Fatalf("unexpected answer %q", answer)
+	}
+
+	m.Close()
+
+	_, err = m.Dial(context.Background(), target)
+	if !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected the mux to be closed, got %v", err)
+	}
+}
+
+func TestMuxSocketFailure(t *testing.T) {
+	target := listenEcho(t, "")
+	m := listen(t, 2)
+
+	conn, err := m.Dial(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	socket := slices.IndexFunc(m.sockets, func(socket *net.UDPConn) bool {
+		return socket.LocalAddr().String() == conn.LocalAddr().String()
+	})
+
+	// What the read loop does on an error that is not temporary
+	broken := errors.New("broken")
+	m.fail(socket, broken)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Read(make([]byte, 1500))
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("expected the pending read to fail, got %v", err)
+	}
+
+	// The target is still open on the failed socket, the other one is used
+	other, err := m.Dial(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if answer := exchange(t, other, "hello"); answer != "hello" {
+		t.Fatalf("unexpected answer %q", answer)
+	}
+
+	m.fail(1-socket, broken)
+
+	_, err = m.Dial(context.Background(), target)
+	if err == nil || errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected the dial to fail without sockets left, got %v", err)
+	}
+}
+
+func TestTemporaryReadErrors(t *testing.T) {
+	if !temporary(&net.OpError{Op: "read", Net: "udp", Err: os.NewSyscallError("recvfrom", syscall.ENOBUFS)}) {
+		t.Error("expected ENOBUFS to be temporary")
+	}
+
+	if temporary(&net.OpError{Op: "read", Net: "udp", Err: os.NewSyscallError("recvfrom", syscall.EBADF)}) {
+		t.Error("expected EBADF to stop the socket")
+	}
+}
+
+func TestMuxSources(t *testing.T) {
+	sources := []netip.Addr{netip.MustParseAddr("127.0.0.2"), netip.MustParseAddr("127.0.0.3")}
+
+	m, err := Listen(Config{Sockets: 1, Sources: sources})
+	if err != nil {
+		t.Skipf("loopback aliases are not available: %s", err)
+	}
+
+	defer m.Close()
+
+	if len(m.sockets) != 2 {
+		t.Fatalf("expected a socket for each source, got %d", len(m.sockets))
+	}
+
+	server, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.MustParseAddrPort("127.0.0.1:0")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Close()
+
+	target := netip.MustParseAddrPort(server.LocalAddr().String())
+	seen := map[netip.Addr]bool{}
+
+	for range 2 {
+		conn, err := m.Dial(context.Background(), target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer conn.Close()
+		conn.Write([]byte("hello"))
+
+		server.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, from, err := server.ReadFromUDPAddrPort(make([]byte, 16))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		seen[from.Addr().Unmap()] = true
+	}
+
+	if !seen[sources[0]] || !seen[sources[1]] {
+		t.Fatalf("expected datagrams from both sources, got %v", seen)
+	}
+
+	// Only IPv4 sockets
+	_, err = m.Dial(context.Background(), netip.MustParseAddrPort("[::1]:9"))
+	if err == nil {
+		t.Fatal("expected IPv6 targets to be rejected")
+	}
+}
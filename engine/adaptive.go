@@ -0,0 +1,171 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Windows with less attempts than this are not used to adjust the rate, their ratios are too noisy
+const ADAPTIVE_MIN_SAMPLES = 20
+
+// AIMD controller of the rate of a RateLimiter, driven by the outcome of the connection attempts.
+//
+// Every window, the rate is halved if the ratio of local resource errors (EADDRNOTAVAIL, EMFILE, ENOBUFS...)
+// is above the threshold, or if the ratio of lost attempts (timeouts and dial errors other than refused
+// connections) is above the baseline plus the threshold. Otherwise the rate is increased by a step.
+// The rate always stays between Min and Max.
+//
+// Most addresses of a scan never answer, so lost attempts are compared against a baseline, the lowest
+// loss ratio seen so far, instead of zero. Losses above it are caused by the rate. The rate starts at Min
+// so the baseline is learned before the network is pushed, losses above the baseline at Min can't be caused
+// by the rate and become the new baseline.
+type AdaptiveRate struct {
+	limiter *RateLimiter
+
+	Min       int
+	Max       int
+	Threshold float64
+	// Added to the rate every window without errors
+	Step int
+
+	// Lowest loss ratio seen in a window
+	baseline float64
+
+	// Outcomes of the current window
+	attempts atomic.Int64
+	local    atomic.Int64
+	lost     atomic.Int64
+}
+
+// Sets the rate of limiter to min
+func NewAdaptiveRate(limiter *RateLimiter, min int, max int, threshold float64) *AdaptiveRate {
+	limiter.SetRate(min)
+
+	return &AdaptiveRate{
+		limiter:   limiter,
+		Min:       min,
+		Max:       max,
+		Threshold: threshold,
+		Step:      (max-min)/20 + 1,
+		baseline:  1,
+	}
+}
+
+// Records the outcome of a connection attempt, a nil controller ignores it
+func (a *AdaptiveRate) Record(err error) {
+	if a == nil {
+		return
+	}
+
+	a.attempts.Add(1)
+
+	switch {
+	case err == nil:
+	case isLocalError(err):
+		a.local.Add(1)
+	case isTimeout(err):
+		a.lost.Add(1)
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET):
+		// The host answered, nothing was lost
+	default:
+		a.lost.Add(1)
+	}
+}
+
+// Records a connection that timed out after it was established
+func (a *AdaptiveRate) RecordTimeout() {
+	if a == nil {
+		return
+	}
+
+	a.lost.Add(1)
+}
+
+// Adjusts the rate with the outcomes of the window and starts a new one.
+// Returns the new rate and the reason when it changed.
+func (a *AdaptiveRate) Adjust() (int, string, bool) {
+	attempts := a.attempts.Swap(0)
+	local := a.local.Swap(0)
+	lost := a.lost.Swap(0)
+
+	rate := a.limiter.Rate()
+	if attempts < ADAPTIVE_MIN_SAMPLES {
+		return rate, "", false
+	}
+
+	localRatio := float64(local) / float64(attempts)
+	lostRatio := min(1, float64(lost)/float64(attempts))
+
+	next := rate
+	reason := ""
+
+	switch {
+	case localRatio > a.Threshold:
+		next = rate / 2
+		reason = fmt.Sprintf("%.1f%% local errors", localRatio*100)
+	case lostRatio > a.baseline+a.Threshold:
+		next = rate / 2
+		reason = fmt.Sprintf("%.1f%% lost, baseline %.1f%%", lostRatio*100, a.baseline*100)
+	default:
+		next = rate + a.Step
+		reason = fmt.Sprintf("%.1f%% lost, %.1f%% local errors", lostRatio*100, localRatio*100)
+	}
+
+	a.baseline = min(a.baseline, lostRatio)
+	if rate == a.Min {
+		a.baseline = lostRatio
+	}
+
+	next = min(max(next, a.Min), a.Max)
+	if next == rate {
+		return rate, "", false
+	}
+
+	a.limiter.SetRate(next)
+	return next, reason, true
+}
+
+// Adjusts the rate every window until stop is closed, every change is written to log
+func (a *AdaptiveRate) Run(window time.Duration, stop <-chan struct{}, log io.Writer) {
+	ticker := time.NewTicker(window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			previous := a.limiter.Rate()
+
+			rate, reason, changed := a.Adjust()
+			if changed {
+				fmt.Fprintf(log, "\nRATE %d/s -> %d/s: %s\n", previous, rate, reason)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Errors caused by running out of something on this machine, like ports or file descriptors
+func isLocalError(err error) bool {
+	return errors.Is(err, syscall.EADDRNOTAVAIL) ||
+		errors.Is(err, syscall.EMFILE) ||
+		errors.Is(err, syscall.ENFILE) ||
+		errors.Is(err, syscall.ENOBUFS) ||
+		errors.Is(err, syscall.ENOMEM) ||
+		errors.Is(err, syscall.EAGAIN)
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
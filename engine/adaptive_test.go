@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+var (
+	TIMEOUT_ERROR = &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	REFUSED_ERROR = &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	LOCAL_ERROR   = &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.EADDRNOTAVAIL)}
+)
+
+// Simulates one window of a network where half of the addresses never answer, a quarter refuse the connection
+// and a quarter accept it. Above capacity, the answers of the live addresses are dropped. Above ports, the
+// machine runs out of local ports.
+func simulateWindow(controller *AdaptiveRate, rate int, capacity int, ports int) {
+	dropped := 0.0
+	if rate > capacity {
+		dropped = float64(rate-capacity) / float64(rate)
+	}
+
+	for i := range rate {
+		if i >= ports {
+			controller.Record(LOCAL_ERROR)
+			continue
+		}
+
+		switch {
+		case i%4 < 2:
+			controller.Record(TIMEOUT_ERROR)
+		case float64(i%100)/100 < dropped:
+			controller.Record(TIMEOUT_ERROR)
+		case i%4 == 2:
+			controller.Record(REFUSED_ERROR)
+		default:
+			controller.Record(nil)
+		}
+	}
+}
+
+// Runs the controller for windows and returns the rates after the first half
+func simulate(t *testing.T, capacity int, ports int, windows int) []int {
+	t.Helper()
+
+	controller := NewAdaptiveRate(NewRateLimiter(1), 100, 5000, 0.02)
+
+	var rates []int
+	for i := range windows {
+		rate := controller.limiter.Rate()
+		if rate < controller.Min || rate > controller.Max {
+			t.Fatalf("rate %d out of bounds", rate)
+		}
+
+		simulateWindow(controller, rate, capacity, ports)
+		controller.Adjust()
+
+		if i >= windows/2 {
+			rates = append(rates, rate)
+		}
+	}
+
+	return rates
+}
+
+func TestAdaptiveRateConvergesToCapacity(t *testing.T) {
+	capacity := 2000
+	rates := simulate(t, capacity, 1<<30, 400)
+
+	sum := 0
+	for _, rate := range rates {
+		sum += rate
+
+		// Additive increase only overshoots by a step before halving
+		if rate > capacity+capacity/4 {
+			t.Fatalf("rate %d is too far above the capacity of %d", rate, capacity)
+		}
+
+		if rate < capacity/3 {
+			t.Fatalf("rate %d is too far below the capacity of %d", rate, capacity)
+		}
+	}
+
+	mean := sum / len(rates)
+	if mean < capacity/2 || mean > capacity {
+		t.Fatalf("expected the mean rate to be between %d and %d, got %d", capacity/2, capacity, mean)
+	}
+}
+
+func TestAdaptiveRateLocalErrors(t *testing.T) {
+	// The network could take more than the machine
+	ports := 1500
+	rates := simulate(t, 1<<30, ports, 400)
+
+	for _, rate := range rates {
+		if rate > ports+ports/4 {
+			t.Fatalf("rate %d is too far above the %d local ports", rate, ports)
+		}
+	}
+}
+
+func TestAdaptiveRateBounds(t *testing.T) {
+	controller := NewAdaptiveRate(NewRateLimiter(1), 100, 300, 0.02)
+
+	// Every attempt is lost, the baseline is learned at the minimum rate
+	for range 30 {
+		for range 200 {
+			controller.Record(TIMEOUT_ERROR)
+		}
+
+		controller.Adjust()
+	}
+
+	if controller.limiter.Rate() != 300 {
+		t.Fatalf("expected the rate to reach the maximum of 300, got %d", controller.limiter.Rate())
+	}
+
+	for range 10 {
+		for range 200 {
+			controller.Record(LOCAL_ERROR)
+		}
+
+		controller.Adjust()
+	}
+
+	if controller.limiter.Rate() != 100 {
+		t.Fatalf("expected the rate to drop to the minimum of 100, got %d", controller.limiter.Rate())
+	}
+}
@@ -0,0 +1,125 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Kyagara/hagelslag/scanners"
+	"github.com/Kyagara/hagelslag/targets"
+)
+
+// Response of the loopback servers, large enough for read to need its buffer
+var BENCHMARK_RESPONSE = bytes.Repeat([]byte("hagelslag"), 4096)
+
+// Same as loopbackScanner, reading with a new buffer for every scan like read did before the buffers were pooled
+type unpooledScanner struct {
+	loopbackScanner
+}
+
+func (s unpooledScanner) Scan(_ context.Context, target netip.AddrPort, conn net.Conn) ([]byte, int64, error) {
+	var response []byte
+	buf := make([]byte, scanners.INITIAL_BUFFER_SIZE)
+
+	for {
+		n, err := conn.Read(buf)
+		response = append(response, buf[:n]...)
+
+		if err == io.EOF {
+			return response, 0, nil
+		}
+
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+}
+
+// Previous design, a goroutine per target with the amount of scans limited by a semaphore
+func runGoroutinePerTarget(e *Engine, source targets.Source) {
+	semaphore := make(chan struct{}, e.options.Concurrency)
+
+	var tasks sync.WaitGroup
+	for {
+		target, ok := source.Next()
+		if !ok {
+			break
+		}
+
+		semaphore <- struct{}{}
+		tasks.Add(1)
+
+		go func() {
+			defer tasks.Done()
+			defer func() { <-semaphore }()
+			defer source.Done(target)
+			e.spawn(context.Background(), nil, e.services[0], target)
+		}()
+	}
+
+	tasks.Wait()
+}
+
+// Reports the throughput and the peak amount of goroutines while scan runs
+func benchmarkLoopback(b *testing.B, scan func(source targets.Source)) {
+	target := listenLoopback(b, BENCHMARK_RESPONSE)
+	source := &repeatSource{targets: []netip.AddrPort{target}, count: int64(b.N)}
+
+	peak := runtime.NumGoroutine()
+	stop := make(chan struct{})
+	sampled := make(chan struct{})
+
+	go func() {
+		defer close(sampled)
+
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				peak = max(peak, runtime.NumGoroutine())
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	scan(source)
+
+	b.StopTimer()
+	close(stop)
+	<-sampled
+
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "targets/s")
+	b.ReportMetric(float64(peak), "goroutines")
+}
+
+func BenchmarkPoolLoopback(b *testing.B) {
+	var saved atomic.Int64
+
+	benchmarkLoopback(b, func(source targets.Source) {
+		e := newEngine(b, loopbackOptions(&saved, source, 64, false))
+		e.Run(context.Background())
+	})
+}
+
+func BenchmarkGoroutinePerTargetLoopback(b *testing.B) {
+	var saved atomic.Int64
+
+	benchmarkLoopback(b, func(source targets.Source) {
+		options := loopbackOptions(&saved, source, 64, false)
+		options.Scanners = []scanners.Scanner{unpooledScanner{}}
+		runGoroutinePerTarget(newEngine(b, options), source)
+	})
+}
@@ -0,0 +1,29 @@
+//go:build linux
+
+package engine
+
+import (
+	"net"
+	"syscall"
+)
+
+// Control function of the sockets bound to the interface, see net.Dialer
+func bindToInterface(name string) (func(network string, address string, c syscall.RawConn) error, error) {
+	_, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(network string, address string, c syscall.RawConn) error {
+		var bindErr error
+
+		err := c.Control(func(fd uintptr) {
+			bindErr = syscall.BindToDevice(int(fd), name)
+		})
+		if err != nil {
+			return err
+		}
+
+		return bindErr
+	}, nil
+}
@@ -0,0 +1,35 @@
+//go:build linux
+
+package engine
+
+import (
+	"context"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+)
+
+func TestEngineInterface(t *testing.T) {
+	open := listenLoopback(t, []byte("hello"))
+
+	var saved atomic.Int64
+	source := &repeatSource{targets: []netip.AddrPort{open}, count: 4}
+
+	options := loopbackOptions(&saved, source, 2, false)
+	options.Interface = "lo"
+
+	err := newEngine(t, options).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if saved.Load() != 4 {
+		t.Fatalf("expected 4 results over the loopback interface, got %d", saved.Load())
+	}
+
+	options.Interface = "missing0"
+	_, err = New(options)
+	if err == nil {
+		t.Fatal("expected a missing interface to be rejected")
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package engine
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Sockets can only be bound to an interface on Linux
+func bindToInterface(name string) (func(network string, address string, c syscall.RawConn) error, error) {
+	return nil, errors.New("binding to an interface is only supported on Linux")
+}
@@ -0,0 +1,1323 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Kyagara/hagelslag/proxy"
+	"github.com/Kyagara/hagelslag/scanners"
+	"github.com/Kyagara/hagelslag/storage"
+	"github.com/Kyagara/hagelslag/targets"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Configuration of a scan, zero values are replaced by the defaults of the CLI where noted
+type Options struct {
+	// Every target is scanned by each scanner used on its port
+	Scanners []scanners.Scanner
+	// Scan workers of each scanner, parallel to Scanners, 0 gives the scanner an even part of what
+	// Concurrency has left. Default: Concurrency split evenly between the scanners
+	Shares []int
+
+	// Walked when Sources is empty and not rescanning
+	Targets  targets.Targets
+	Excluded targets.Exclusions
+	// Every scanner is used on every port, default: each scanner on its own ports
+	Ports []uint16
+	// Visit every address for each port instead of every port for each address
+	PortMajor bool
+	// 'sequential' or 'random', default: sequential
+	Order string
+	// Seed of the random order
+	Seed uint64
+	// Part of the targets scanned, default: every target
+	Shard targets.Shard
+	// Amount of walkers, each one walks its own sub-shard of Shard, default: 1
+	SubShards int
+	// Position to start each sub-shard from, set when resuming
+	Positions []uint64
+
+	// Used instead of walking Targets, for targets coming from somewhere else
+	Sources []targets.Source
+
+	// Rescan the hosts in a collection or a file instead of walking the targets
+	FromDB   string
+	FromFile string
+	// Only documents matching the filter and not seen since OlderThan are rescanned
+	DBFilter  bson.M
+	OlderThan time.Duration
+
+	// Limits that stop the scan, 0 means no limit
+	MaxTargets int64
+	MaxResults int64
+	Duration   time.Duration
+	// Results of the scans resumed from, counted in the stats and by MaxResults
+	PreviousResults int64
+
+	// Database the results are saved to, nothing is saved without it
+	URI string
+	// Where the results of each scanner are saved instead of the database, called once per scanner when starting.
+	// A MemoryStore of the storage package keeps them in memory
+	Stores func(scanner string) storage.Store
+	// Only connect, every connection is a result
+	OnlyConnect bool
+
+	// Connection attempts per second, 0 means no limit
+	Rate int
+	// Adjust the rate between MinRate and MaxRate, default: MaxRate is Rate and MinRate a tenth of MaxRate
+	Adaptive          bool
+	MinRate           int
+	MaxRate           int
+	AdaptiveWindow    time.Duration
+	AdaptiveThreshold float64
+
+	// Prefix lengths of the networks the politeness limits apply to, default: 24 and 48
+	PolitePrefix  int
+	PolitePrefix6 int
+	// Concurrent probes and probes per second to a network, 0 means no limit
+	MaxPerNetwork int
+	NetworkRate   float64
+	// Concurrent probes to an ASN of the table, 0 means no limit
+	ASNs      *ASNTable
+	MaxPerASN int
+
+	// Amount of scan workers of all scanners, the limit of connections open at the same time, default: 1000
+	Concurrency int
+	// Split the scan in a connect stage limited by Concurrency and a scan stage limited by ScanConcurrency
+	Pipeline bool
+	// Split between the scanners like Concurrency, default: 100
+	ScanConcurrency int
+
+	// Phases set override the timeouts of every scanner
+	Timeouts scanners.Timeouts
+	// Connection attempts after one timed out or failed because of the machine, the first one waits
+	// RetryBackoff and every next one twice as long as the previous, default: 500ms
+	Retries      int
+	RetryBackoff time.Duration
+	// Datagrams sent again when the answer doesn't arrive, only used with udp scanners
+	Retransmits int
+	// Opens every connection instead of the network, SourceAddrs, Interface, Proxies and UDPSockets configure
+	// the network and can't be used with it. Closed when the scan ends
+	Transport Transport
+	// Sockets the datagrams of every udp scanner are sent from, the answers are passed to the scan of the
+	// target that sent them. 0 gives every udp scan its own socket
+	UDPSockets int
+
+	// Local addresses the connections are made from, each family used in turn. Targets of a family without
+	// addresses are connected to from the address chosen by the system, default: the address chosen by the system
+	SourceAddrs []netip.Addr
+	// Network interface every socket is bound to, only on Linux. Default: the interface of the route to the target
+	Interface string
+	// Connections are tunneled through the proxies in turn, each one limited to its MaxConns. Only used with tcp
+	// scanners, the proxies are connected to from SourceAddrs. Default: the targets are connected to directly
+	Proxies []proxy.Proxy
+
+	// Time the running scans get to finish when stopping, before they are aborted
+	DrainTimeout time.Duration
+
+	// Sends a probe to every target first, only the ones that answer are queued for the scan workers and
+	// the rate limits the probes instead of the connections. Closed when the scan ends, nil disables it
+	Prescan Prober
+	// Time a target waits for the answer to its probe, default: 1s
+	PrescanWait time.Duration
+
+	// Called with every result from the workers at the same time, Done is closed after the last call
+	OnResult func(Result)
+	// Errors of single targets and rate changes are written to it from the workers, default: os.Stderr
+	Log io.Writer
+}
+
+// Reports whether the targets come from a collection or a file
+func (o Options) Rescanning() bool {
+	return o.FromDB != "" || o.FromFile != ""
+}
+
+// Host that answered, Data is nil with OnlyConnect
+type Result struct {
+	// Name of the scanner it answered to
+	Scanner string
+	Target  netip.AddrPort
+	Latency int64
+	Data    []byte
+	// Proxy the connection went through, otherwise the local address it was made from if known
+	Egress string
+}
+
+// Counters of a scan
+type Stats struct {
+	// Targets sent to be scanned
+	Issued int64
+	// Connections attempted and the ones that succeeded
+	Dialed    int64
+	Connected int64
+	// Connections scanned by the scan stage of the pipeline
+	Scanned int64
+	// Probes sent by the prescan and the ones answered
+	Sent     int64
+	Answered int64
+	// Results found, including the ones of the scans resumed from
+	Results int64
+	// Connections waiting for the scan stage of every scanner
+	Queued int
+	// Connection attempts per second currently allowed, 0 if there is no limit
+	Rate int
+	// Steps of the walk taken and the total amount of steps
+	Position uint64
+	Total    uint64
+}
+
+// Scan of the targets in the options. The sources are walked by producers, which queue every target
+// for a fixed pool of scan workers, the workers connect, scan and save (when successful).
+type Engine struct {
+	options Options
+
+	// Every scanner with its workers and queues, by port. Ports without scanners use all of them
+	services []*service
+	byPort   map[uint16][]*service
+	// Walked for every address
+	ports []uint16
+
+	sources []targets.Source
+	// Only set when walking the targets
+	iterators []*targets.TargetIterator
+
+	limiter *RateLimiter
+	// Adjusts the rate every window, nil if the rate is fixed
+	adaptive *AdaptiveRate
+	// Budgets of every network and ASN, nil if there are no limits
+	polite *Politeness
+	// Nil without Prescan
+	prescan *prescan
+	// Opens the connections, Options.Transport or the network
+	transport Transport
+	// Nil with Options.Transport
+	network *networkTransport
+
+	client *mongo.Client
+
+	issued    atomic.Int64
+	dialed    atomic.Int64
+	connected atomic.Int64
+	scanned   atomic.Int64
+	sent      atomic.Int64
+	answered  atomic.Int64
+	results   atomic.Int64
+
+	// Stops the scan with the reason as the cause
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+	err    error
+}
+
+// Scanner of the scan, with its own share of the workers, queues and collection
+type service struct {
+	scanner  scanners.Scanner
+	timeouts scanners.Timeouts
+	// Workers of the scan workers and, with Pipeline, of the scan stage
+	concurrency     int
+	scanConcurrency int
+
+	// Targets waiting for the scan workers
+	tasks chan task
+	// Open connections waiting for the scan stage, only used with Pipeline
+	probes chan probe
+
+	// Nil without a database or Stores
+	collection storage.Store
+}
+
+// Target taken from a source, expanded into a task for every scanner used on its port
+type pending struct {
+	target netip.AddrPort
+	// Source the target came from, notified once every task is done
+	source targets.Source
+	// Tasks not finished yet
+	remaining atomic.Int32
+	// Set when a task was aborted, the target stays pending in the source
+	aborted atomic.Bool
+}
+
+// Target waiting for a scan worker of a scanner
+type task struct {
+	*pending
+	service *service
+}
+
+// Connection made by the connect stage, waiting to be scanned
+type probe struct {
+	task task
+	conn net.Conn
+}
+
+// Validates the options and prepares the scan, nothing is started until Start
+func New(options Options) (*Engine, error) {
+	if len(options.Scanners) == 0 || slices.Contains(options.Scanners, nil) {
+		return nil, fmt.Errorf("a scanner is required")
+	}
+
+	for _, scanner := range options.Scanners {
+		switch scanner.Network() {
+		case "tcp":
+		case "udp":
+			if _, ok := scanner.(scanners.DatagramScanner); !ok {
+				return nil, fmt.Errorf("'%s' uses udp and doesn't exchange datagrams", scanner.Name())
+			}
+		default:
+			return nil, fmt.Errorf("unknown network '%s' of '%s'", scanner.Network(), scanner.Name())
+		}
+	}
+
+	if options.Order == "" {
+		options.Order = "sequential"
+	}
+
+	if options.Shard.Count == 0 {
+		options.Shard = targets.NO_SHARD
+	}
+
+	if options.SubShards == 0 {
+		options.SubShards = 1
+	}
+
+	if options.Concurrency == 0 {
+		options.Concurrency = 1000
+	}
+
+	if options.ScanConcurrency == 0 {
+		options.ScanConcurrency = 100
+	}
+
+	if options.RetryBackoff == 0 {
+		options.RetryBackoff = 500 * time.Millisecond
+	}
+
+	if options.PolitePrefix == 0 {
+		options.PolitePrefix = 24
+	}
+
+	if options.PolitePrefix6 == 0 {
+		options.PolitePrefix6 = 48
+	}
+
+	if options.AdaptiveWindow == 0 {
+		options.AdaptiveWindow = 1 * time.Second
+	}
+
+	if options.AdaptiveThreshold == 0 {
+		options.AdaptiveThreshold = 0.05
+	}
+
+	if options.PrescanWait == 0 {
+		options.PrescanWait = 1 * time.Second
+	}
+
+	if options.Log == nil {
+		options.Log = os.Stderr
+	}
+
+	e := &Engine{
+		options: options,
+		ports:   WalkedPorts(options.Scanners, options.Ports),
+		byPort:  make(map[uint16][]*service),
+		done:    make(chan struct{}),
+	}
+
+	if options.SubShards < 1 {
+		return nil, fmt.Errorf("sub-shards must be at least 1")
+	}
+
+	if options.FromDB != "" && options.FromFile != "" {
+		return nil, fmt.Errorf("-from-db and -from-file can't be used together")
+	}
+
+	if options.FromDB != "" && options.URI == "" {
+		return nil, fmt.Errorf("-from-db needs a database")
+	}
+
+	if options.Rate < 0 {
+		return nil, fmt.Errorf("rate can't be negative")
+	}
+
+	if options.Rate > 0 {
+		e.limiter = NewRateLimiter(options.Rate)
+	}
+
+	if options.PolitePrefix < 0 || options.PolitePrefix > 32 || options.PolitePrefix6 < 0 || options.PolitePrefix6 > 128 {
+		return nil, fmt.Errorf("invalid politeness prefix length")
+	}
+
+	if options.MaxPerNetwork < 0 || options.NetworkRate < 0 || options.MaxPerASN < 0 {
+		return nil, fmt.Errorf("politeness limits can't be negative")
+	}
+
+	if options.MaxPerASN > 0 && options.ASNs == nil {
+		return nil, fmt.Errorf("-max-per-asn needs -asn-table")
+	}
+
+	if options.MaxPerNetwork > 0 || options.NetworkRate > 0 || options.MaxPerASN > 0 {
+		e.polite = NewPoliteness(options.PolitePrefix, options.PolitePrefix6, options.MaxPerNetwork, options.NetworkRate, options.ASNs, options.MaxPerASN)
+	}
+
+	if options.Adaptive {
+		maxRate := options.MaxRate
+		if maxRate == 0 {
+			maxRate = options.Rate
+		}
+
+		if maxRate < 1 {
+			return nil, fmt.Errorf("-adaptive needs -max-rate or -rate")
+		}
+
+		minRate := options.MinRate
+		if minRate == 0 {
+			minRate = max(1, maxRate/10)
+		}
+
+		if minRate < 1 || minRate > maxRate {
+			return nil, fmt.Errorf("-min-rate must be between 1 and -max-rate")
+		}
+
+		if options.AdaptiveWindow < 0 {
+			return nil, fmt.Errorf("adaptive window must be positive")
+		}
+
+		// The limiter starts at the minimum rate
+		e.options.Rate = maxRate
+		e.limiter = NewRateLimiter(minRate)
+		e.adaptive = NewAdaptiveRate(e.limiter, minRate, maxRate, options.AdaptiveThreshold)
+	}
+
+	if options.Concurrency < 1 {
+		return nil, fmt.Errorf("concurrency must be at least 1")
+	}
+
+	if options.Timeouts.Connect < 0 || options.Timeouts.FirstByte < 0 || options.Timeouts.Exchange < 0 || options.Timeouts.Idle < 0 {
+		return nil, fmt.Errorf("timeouts can't be negative")
+	}
+
+	if options.Retries < 0 || options.Retransmits < 0 {
+		return nil, fmt.Errorf("retries and retransmits can't be negative")
+	}
+
+	if options.RetryBackoff < 0 {
+		return nil, fmt.Errorf("retry backoff must be positive")
+	}
+
+	if options.Transport != nil {
+		if len(options.SourceAddrs) > 0 || options.Interface != "" || len(options.Proxies) > 0 || options.UDPSockets != 0 {
+			return nil, fmt.Errorf("source addresses, interface, proxies and udp sockets can't be used with a transport")
+		}
+
+		e.transport = options.Transport
+	} else {
+		network, err := newNetworkTransport(options)
+		if err != nil {
+			return nil, err
+		}
+
+		e.network = network
+		e.transport = network
+	}
+
+	if options.URI != "" && options.Stores != nil {
+		return nil, fmt.Errorf("a database and stores can't be used together")
+	}
+
+	if options.DrainTimeout < 0 {
+		return nil, fmt.Errorf("drain timeout can't be negative")
+	}
+
+	if options.Pipeline {
+		if options.OnlyConnect {
+			return nil, fmt.Errorf("-pipeline can't be used with -only-connect")
+		}
+
+		// Dialing UDP always succeeds, there is nothing to filter
+		for _, scanner := range options.Scanners {
+			if scanner.Network() != "tcp" {
+				return nil, fmt.Errorf("-pipeline requires tcp scanners, '%s' uses %s", scanner.Name(), scanner.Network())
+			}
+		}
+
+		if options.ScanConcurrency < 1 {
+			return nil, fmt.Errorf("scan concurrency must be at least 1")
+		}
+	}
+
+	if options.Prescan != nil {
+		// A SYN tells nothing about UDP
+		for _, scanner := range options.Scanners {
+			if scanner.Network() != "tcp" {
+				return nil, fmt.Errorf("the prescan requires tcp scanners, '%s' uses %s", scanner.Name(), scanner.Network())
+			}
+		}
+
+		if options.PrescanWait < 0 {
+			return nil, fmt.Errorf("prescan wait can't be negative")
+		}
+
+		e.prescan = newPrescan(options.Prescan, options.PrescanWait)
+	}
+
+	err := e.newServices()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(options.Sources) > 0 || options.Rescanning() {
+		return e, nil
+	}
+
+	if len(options.Positions) > 0 && len(options.Positions) != options.SubShards {
+		return nil, fmt.Errorf("expected a position for each of the %d sub-shards, got %d", options.SubShards, len(options.Positions))
+	}
+
+	e.iterators, err = e.newIterators()
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// Ports walked for every address, ports if set, otherwise the ports of every scanner without duplicates
+func WalkedPorts(list []scanners.Scanner, ports []uint16) []uint16 {
+	if len(ports) > 0 {
+		return ports
+	}
+
+	var walked []uint16
+	for _, scanner := range list {
+		for _, port := range scanner.Ports() {
+			if !slices.Contains(walked, port) {
+				walked = append(walked, port)
+			}
+		}
+	}
+
+	return walked
+}
+
+// Creates the service of every scanner with its share of the workers
+func (e *Engine) newServices() error {
+	count := len(e.options.Scanners)
+
+	shares, err := split(e.options.Concurrency, e.options.Shares, count)
+	if err != nil {
+		return fmt.Errorf("invalid concurrency shares: %s", err)
+	}
+
+	// Only used with Pipeline
+	var scanShares []int
+	if e.options.Pipeline {
+		scanShares, err = split(e.options.ScanConcurrency, nil, count)
+		if err != nil {
+			return fmt.Errorf("invalid scan concurrency: %s", err)
+		}
+	}
+
+	for i, scanner := range e.options.Scanners {
+		for _, other := range e.services {
+			if other.scanner.Name() == scanner.Name() {
+				return fmt.Errorf("scanner '%s' is used twice", scanner.Name())
+			}
+		}
+
+		service := &service{
+			scanner:     scanner,
+			timeouts:    scanner.Timeouts().Override(e.options.Timeouts),
+			concurrency: shares[i],
+			tasks:       make(chan task, shares[i]),
+		}
+
+		if e.options.Pipeline {
+			service.scanConcurrency = scanShares[i]
+			service.probes = make(chan probe, scanShares[i])
+		}
+
+		e.services = append(e.services, service)
+
+		ports := e.options.Ports
+		if len(ports) == 0 {
+			ports = scanner.Ports()
+		}
+
+		for _, port := range ports {
+			e.byPort[port] = append(e.byPort[port], service)
+		}
+	}
+
+	return nil
+}
+
+// Splits total in count parts, the parts set in fixed are kept and what is left is split evenly between the others
+func split(total int, fixed []int, count int) ([]int, error) {
+	if len(fixed) > count {
+		return nil, fmt.Errorf("%d shares for %d scanners", len(fixed), count)
+	}
+
+	parts := make([]int, count)
+	left := total
+	unset := 0
+
+	for i := range parts {
+		if i >= len(fixed) || fixed[i] == 0 {
+			unset++
+			continue
+		}
+
+		if fixed[i] < 0 {
+			return nil, fmt.Errorf("shares can't be negative")
+		}
+
+		parts[i] = fixed[i]
+		left -= fixed[i]
+	}
+
+	if left < 0 {
+		return nil, fmt.Errorf("shares add up to more than %d", total)
+	}
+
+	if left < unset {
+		return nil, fmt.Errorf("%d workers left for %d scanners, at least one each is needed", left, unset)
+	}
+
+	if unset == 0 {
+		return parts, nil
+	}
+
+	// The first ones get what doesn't divide evenly
+	extra := left % unset
+	for i := range parts {
+		if parts[i] != 0 {
+			continue
+		}
+
+		parts[i] = left / unset
+		if extra > 0 {
+			parts[i]++
+			extra--
+		}
+	}
+
+	return parts, nil
+}
+
+// Scanners used on the port, every scanner if none of them uses it
+func (e *Engine) servicesFor(port uint16) []*service {
+	if services, ok := e.byPort[port]; ok {
+		return services
+	}
+
+	return e.services
+}
+
+// Creates the iterators of every sub-shard, starting from Positions when resuming
+func (e *Engine) newIterators() ([]*targets.TargetIterator, error) {
+	size := e.options.Targets.Size() * uint64(len(e.ports))
+	iterators := make([]*targets.TargetIterator, e.options.SubShards)
+
+	for i := range iterators {
+		shard := e.options.Shard.Split(uint64(i), uint64(e.options.SubShards))
+
+		order, err := targets.NewOrder(e.options.Order, size, e.options.Seed, shard)
+		if err != nil {
+			return nil, err
+		}
+
+		iterators[i] = targets.NewTargetIterator(e.options.Targets, e.options.Excluded, e.ports, e.options.PortMajor, order)
+
+		if len(e.options.Positions) > 0 {
+			iterators[i].SetPosition(e.options.Positions[i])
+		}
+	}
+
+	return iterators, nil
+}
+
+// Creates the sources of the targets, a stream when rescanning, otherwise the iterator of every sub-shard
+func (e *Engine) newSources(ctx context.Context) ([]targets.Source, error) {
+	if len(e.options.Sources) > 0 {
+		return e.options.Sources, nil
+	}
+
+	if e.options.FromFile != "" {
+		stream, err := targets.StreamFile(e.options.FromFile, e.ports, e.options.Excluded, e.logRescanError)
+		if err != nil {
+			return nil, err
+		}
+
+		return []targets.Source{stream}, nil
+	}
+
+	if e.options.FromDB != "" {
+		filter := storage.RescanFilter(e.options.OlderThan, e.options.DBFilter)
+
+		stream, err := storage.StreamCollection(ctx, e.options.URI, e.options.FromDB, filter, e.ports, e.options.Excluded, e.logRescanError)
+		if err != nil {
+			return nil, err
+		}
+
+		return []targets.Source{stream}, nil
+	}
+
+	sources := make([]targets.Source, len(e.iterators))
+	for i, iterator := range e.iterators {
+		sources[i] = iterator
+	}
+
+	return sources, nil
+}
+
+// Connects to the database and starts the scan in the background, Done is closed when it ends.
+// Canceling ctx stops the scan, the running scans get DrainTimeout to finish before they are aborted.
+func (e *Engine) Start(ctx context.Context) error {
+	if e.options.URI != "" {
+		client, err := storage.Connect(ctx, e.options.URI)
+		if err != nil {
+			e.release()
+			return err
+		}
+
+		e.client = client
+
+		for _, service := range e.services {
+			service.collection = storage.Collection(client, service.scanner.Name())
+		}
+	}
+
+	if e.options.Stores != nil {
+		for _, service := range e.services {
+			// A nil store would not be a nil interface
+			if store := e.options.Stores(service.scanner.Name()); store != nil {
+				service.collection = store
+			}
+		}
+	}
+
+	sources, err := e.newSources(ctx)
+	if err != nil {
+		e.release()
+		return err
+	}
+
+	if e.network != nil && e.options.UDPSockets > 0 && slices.ContainsFunc(e.services, func(service *service) bool { return service.scanner.Network() == "udp" }) {
+		err = e.network.listen(e.options.UDPSockets, e.options.SourceAddrs)
+		if err != nil {
+			e.release()
+			return err
+		}
+	}
+
+	e.sources = sources
+	e.results.Store(e.options.PreviousResults)
+
+	ctx, e.cancel = context.WithCancelCause(ctx)
+
+	// Only set when a duration is set
+	var deadline *time.Timer
+	if e.options.Duration > 0 {
+		deadline = time.AfterFunc(e.options.Duration, func() {
+			e.stop(fmt.Sprintf("duration (%s) reached", e.options.Duration))
+		})
+	}
+
+	running := e.run(ctx)
+
+	go func() {
+		<-running
+
+		if deadline != nil {
+			deadline.Stop()
+		}
+
+		// Stopped before every target was scanned
+		if ctx.Err() != nil {
+			e.err = context.Cause(ctx)
+		}
+
+		e.cancel(nil)
+		e.release()
+		close(e.done)
+	}()
+
+	return nil
+}
+
+// Starts the scan and waits for it to end, see Err for the returned error
+func (e *Engine) Run(ctx context.Context) error {
+	err := e.Start(ctx)
+	if err != nil {
+		return err
+	}
+
+	<-e.done
+	return e.err
+}
+
+// Closed once every target was scanned, or once the scan was stopped and the running scans are done
+func (e *Engine) Done() <-chan struct{} {
+	return e.done
+}
+
+// Nil if every target was scanned, otherwise why the scan stopped: the cause of the cancellation
+// of the context given to Start, or the limit that was reached. Only valid after Done is closed.
+func (e *Engine) Err() error {
+	return e.err
+}
+
+// Counters of the scan, valid once Start returned
+func (e *Engine) Stats() Stats {
+	stats := Stats{
+		Issued:    e.issued.Load(),
+		Dialed:    e.dialed.Load(),
+		Connected: e.connected.Load(),
+		Scanned:   e.scanned.Load(),
+		Sent:      e.sent.Load(),
+		Answered:  e.answered.Load(),
+		Results:   e.results.Load(),
+	}
+
+	for _, service := range e.services {
+		stats.Queued += len(service.probes)
+	}
+
+	if e.limiter != nil {
+		stats.Rate = e.limiter.Rate()
+	}
+
+	for _, source := range e.sources {
+		position, total := source.Progress()
+		stats.Position += position
+		stats.Total += total
+	}
+
+	return stats
+}
+
+// Low-water mark of each sub-shard, nil if the targets are not walked
+func (e *Engine) Positions() []uint64 {
+	if e.iterators == nil {
+		return nil
+	}
+
+	positions := make([]uint64, len(e.iterators))
+	for i, iterator := range e.iterators {
+		positions[i] = iterator.LowWaterMark()
+	}
+
+	return positions
+}
+
+// Ports walked for every address
+func (e *Engine) Ports() []uint16 {
+	return e.ports
+}
+
+// Options after the defaults were applied
+func (e *Engine) Options() Options {
+	return e.options
+}
+
+// Runs the producers and workers, the returned channel is closed when all of them are done
+func (e *Engine) run(ctx context.Context) <-chan struct{} {
+	// Scans already started are not canceled with ctx, only when the drain deadline is reached
+	work, abort := context.WithCancel(context.WithoutCancel(ctx))
+
+	var producers sync.WaitGroup
+	for _, source := range e.sources {
+		producers.Add(1)
+		go e.produce(ctx, source, &producers)
+	}
+
+	var workers sync.WaitGroup
+	// Only used with Pipeline
+	var scanners sync.WaitGroup
+
+	for _, service := range e.services {
+		for range service.concurrency {
+			workers.Add(1)
+			go e.scanWorker(ctx, work, service, &workers)
+		}
+
+		for range service.scanConcurrency {
+			scanners.Add(1)
+			go e.scanProbes(work, service, &scanners)
+		}
+	}
+
+	// Only used with Prescan
+	var receiver sync.WaitGroup
+	if e.prescan != nil {
+		receiver.Add(2)
+		go e.receive(ctx, work, &receiver)
+		go e.dispatch(ctx, &receiver)
+	}
+
+	done := make(chan struct{})
+
+	if e.adaptive != nil {
+		go e.adaptive.Run(e.options.AdaptiveWindow, done, e.options.Log)
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+
+		select {
+		case <-time.After(e.options.DrainTimeout):
+			abort()
+		case <-done:
+		}
+	}()
+
+	go func() {
+		// Every stage drains its queue before the next one is closed
+		producers.Wait()
+
+		if e.prescan != nil {
+			close(e.prescan.produced)
+			receiver.Wait()
+			// Probed after the receiver stopped
+			e.abortWaiting()
+		}
+
+		for _, service := range e.services {
+			close(service.tasks)
+		}
+
+		workers.Wait()
+
+		if e.options.Pipeline {
+			for _, service := range e.services {
+				close(service.probes)
+			}
+
+			scanners.Wait()
+		}
+
+		abort()
+		close(done)
+	}()
+
+	return done
+}
+
+// Walks the source and queues every target for the scan workers of each scanner used on its port, until the
+// source is done or ctx is canceled. Blocks while a queue is full, the walk never gets ahead of the workers
+// of a scanner by more than the size of its queue.
+func (e *Engine) produce(ctx context.Context, source targets.Source, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	// Nothing is taken from it anymore, the reader of a stream would wait forever
+	if stoppable, ok := source.(targets.StoppableSource); ok {
+		defer stoppable.Stop()
+	}
+
+	// Targets over the politeness budgets, retried before taking new ones from the source.
+	// They stay pending in the source, a resumed scan will start from them.
+	var deferred []netip.AddrPort
+	exhausted := false
+	lastRetry := time.Now()
+
+	for {
+		var target netip.AddrPort
+		found := false
+
+		if len(deferred) > 0 && e.shouldRetry(&lastRetry) {
+			target, found = e.takeDeferred(&deferred)
+		}
+
+		if !found && (exhausted || len(deferred) >= MAX_DEFERRED) {
+			if len(deferred) == 0 {
+				return
+			}
+
+			// Nothing fits, wait for a budget to be released
+			select {
+			case <-e.polite.Released():
+				lastRetry = time.Time{}
+			case <-time.After(POLITENESS_RETRY):
+			case <-ctx.Done():
+				return
+			}
+
+			continue
+		}
+
+		if !found {
+			next, ok := source.Next()
+			if !ok {
+				exhausted = true
+				continue
+			}
+
+			// The target stays pending, a resumed scan will start from it
+			if e.issued.Add(1) > e.options.MaxTargets && e.options.MaxTargets > 0 {
+				e.issued.Add(-1)
+				e.stop(fmt.Sprintf("max targets (%d) reached", e.options.MaxTargets))
+				return
+			}
+
+			if !e.polite.Acquire(next.Addr()) {
+				deferred = append(deferred, next)
+				continue
+			}
+
+			target = next
+		}
+
+		pending := &pending{target: target, source: source}
+		pending.remaining.Store(int32(len(e.servicesFor(target.Port()))))
+
+		queued := false
+		if e.prescan != nil {
+			queued = e.probe(ctx, pending)
+		} else {
+			queued = e.queue(ctx, pending)
+		}
+
+		if !queued {
+			return
+		}
+	}
+}
+
+// Queues a task of the target for every scanner used on its port, returns false if ctx was canceled first
+func (e *Engine) queue(ctx context.Context, pending *pending) bool {
+	services := e.servicesFor(pending.target.Port())
+
+	for i, service := range services {
+		select {
+		case service.tasks <- task{pending: pending, service: service}:
+		case <-ctx.Done():
+			// The tasks not queued are aborted, the target stays pending
+			for range services[i:] {
+				e.complete(pending, true)
+			}
+
+			return false
+		}
+	}
+
+	return true
+}
+
+// Reports whether the deferred targets should be tried again, after a budget was released or some time passed
+func (e *Engine) shouldRetry(lastRetry *time.Time) bool {
+	retry := time.Since(*lastRetry) >= POLITENESS_RETRY
+
+	select {
+	case <-e.polite.Released():
+		retry = true
+	default:
+	}
+
+	if retry {
+		*lastRetry = time.Now()
+	}
+
+	return retry
+}
+
+// Removes and returns the first deferred target that fits in its budgets
+func (e *Engine) takeDeferred(deferred *[]netip.AddrPort) (netip.AddrPort, bool) {
+	for i, target := range *deferred {
+		if e.polite.Acquire(target.Addr()) {
+			*deferred = slices.Delete(*deferred, i, i+1)
+			return target, true
+		}
+	}
+
+	return netip.AddrPort{}, false
+}
+
+// Long lived worker of the pool of a scanner, scans the queued targets one at a time until its tasks are closed.
+// No new scan is started once ctx is canceled, the ones already started use work.
+// With Pipeline it only connects, the scan stage does the rest.
+func (e *Engine) scanWorker(ctx context.Context, work context.Context, service *service, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for task := range service.tasks {
+		// Targets left in the queue when stopping stay pending, a resumed scan will start from them.
+		// With Prescan, the rate was already waited for when probing.
+		if ctx.Err() != nil || (e.prescan == nil && !e.limiter.Wait(ctx.Done())) {
+			e.complete(task.pending, true)
+			continue
+		}
+
+		if e.options.Pipeline {
+			e.connect(work, ctx.Done(), task)
+			continue
+		}
+
+		e.finish(work, task, e.spawn(work, ctx.Done(), service, task.target))
+	}
+}
+
+// Completes a task, hosts that didn't answer in a rescan are marked as offline.
+// Targets aborted by the drain deadline stay pending, a resumed scan will start from them.
+func (e *Engine) finish(ctx context.Context, task task, answered bool) {
+	if !answered && ctx.Err() != nil {
+		e.complete(task.pending, true)
+		return
+	}
+
+	defer e.complete(task.pending, false)
+
+	collection := task.service.collection
+	if answered || !e.options.Rescanning() || collection == nil {
+		return
+	}
+
+	err := storage.MarkOffline(ctx, task.target, collection)
+	if err != nil && ctx.Err() == nil {
+		e.log("\nERROR SAVE " + targets.FormatTarget(task.target) + ": " + err.Error() + "\n")
+	}
+}
+
+// Counts a finished task of the target, the last one releases its budgets and marks it as done in its source
+// unless a task was aborted
+func (e *Engine) complete(pending *pending, aborted bool) {
+	if aborted {
+		pending.aborted.Store(true)
+	}
+
+	if pending.remaining.Add(-1) > 0 {
+		return
+	}
+
+	e.polite.Release(pending.target.Addr())
+
+	if !pending.aborted.Load() {
+		pending.source.Done(pending.target)
+	}
+}
+
+// Scans the target with the scanner of service, returns false if it didn't answer
+func (e *Engine) spawn(ctx context.Context, stop <-chan struct{}, service *service, target netip.AddrPort) bool {
+	// Connection
+	conn, err := e.dial(ctx, stop, target, service.scanner.Network(), service.timeouts.Connect)
+	if err != nil {
+		// Don't log anything
+		return false
+	}
+
+	defer conn.Close()
+	e.connected.Add(1)
+
+	if e.options.OnlyConnect {
+		e.success(Result{Scanner: service.scanner.Name(), Target: target, Egress: egress(conn)})
+		return true
+	}
+
+	return e.scan(ctx, service, target, conn)
+}
+
+// Connect stage of the pipeline, queues the connection for the scan stage of the scanner.
+// The worker waits while the queue is full, slowing down the connect stage to the pace of the scan stage.
+func (e *Engine) connect(ctx context.Context, stop <-chan struct{}, task task) {
+	conn, err := e.dial(ctx, stop, task.target, task.service.scanner.Network(), task.service.timeouts.Connect)
+	if err != nil {
+		e.finish(ctx, task, false)
+		return
+	}
+
+	e.connected.Add(1)
+	task.service.probes <- probe{task: task, conn: conn}
+}
+
+// Dials the target, attempts that timed out or failed because of the machine are retried up to Retries times.
+// Retries wait for the backoff and the rate limiter, refused connections are never retried and
+// no retry is started once stop is closed.
+func (e *Engine) dial(ctx context.Context, stop <-chan struct{}, target netip.AddrPort, network string, timeout time.Duration) (net.Conn, error) {
+	backoff := e.options.RetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		e.dialed.Add(1)
+		conn, err := e.transport.Dial(ctx, network, target, timeout)
+		if ctx.Err() != nil {
+			// Aborted, not an outcome of the network
+			return conn, err
+		}
+
+		e.adaptive.Record(err)
+
+		if err == nil || attempt >= e.options.Retries || !(isTimeout(err) || isLocalError(err)) {
+			return conn, err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-stop:
+			return nil, err
+		}
+
+		backoff *= 2
+
+		if !e.limiter.Wait(stop) {
+			return nil, err
+		}
+	}
+}
+
+// Proxy the connection went through, otherwise the local address it was made from, empty if it is not known
+func egress(conn net.Conn) string {
+	if conn, ok := conn.(*proxy.Conn); ok {
+		return conn.Egress()
+	}
+
+	var local netip.Addr
+	switch addr := conn.LocalAddr().(type) {
+	case *net.TCPAddr:
+		local = addr.AddrPort().Addr()
+	case *net.UDPAddr:
+		local = addr.AddrPort().Addr()
+	}
+
+	if !local.IsValid() || local.IsUnspecified() {
+		return ""
+	}
+
+	return local.Unmap().String()
+}
+
+// Scan stage of the pipeline of a scanner, scans the queued connections one at a time until its probes are closed
+func (e *Engine) scanProbes(ctx context.Context, service *service, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for probe := range service.probes {
+		answered := e.scan(ctx, service, probe.task.target, probe.conn)
+		probe.conn.Close()
+		e.scanned.Add(1)
+		e.finish(ctx, probe.task, answered)
+	}
+}
+
+// Exchanges the scanner protocol over conn and saves the response, returns false if the target didn't answer.
+// The connection is closed when ctx is canceled, aborting the exchange.
+func (e *Engine) scan(ctx context.Context, service *service, target netip.AddrPort, conn net.Conn) bool {
+	address := targets.FormatTarget(target)
+
+	abort := context.AfterFunc(ctx, func() { conn.Close() })
+	defer abort()
+
+	var response []byte
+	var latency int64
+	var err error
+
+	// Lost datagrams are only sent again over UDP, TCP does it by itself
+	if datagrams, ok := service.scanner.(scanners.DatagramScanner); ok && service.scanner.Network() == "udp" {
+		deadlines := newDeadlineConn(conn, service.timeouts, e.options.Retransmits, e.options.RetryBackoff)
+		response, latency, err = datagrams.ScanDatagrams(ctx, target, scanners.NewDatagrams(deadlines))
+	} else {
+		response, latency, err = service.scanner.Scan(ctx, target, newDeadlineConn(conn, service.timeouts, 0, 0))
+	}
+	if len(response) == 0 && err == nil {
+		// No response, or wrong response (not wanted, can be discarded)
+		return false
+	}
+
+	if err != nil {
+		// Aborted, the errors are caused by closing the connection
+		if ctx.Err() != nil {
+			return false
+		}
+
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			e.adaptive.RecordTimeout()
+		}
+
+		// Don't log these errors
+		if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) {
+			return false
+		}
+
+		e.log("\nERROR SCAN " + address + ": " + err.Error() + "\n")
+		return false
+	}
+
+	result := Result{Scanner: service.scanner.Name(), Target: target, Latency: latency, Data: response, Egress: egress(conn)}
+
+	// The host answered, even if saving fails it shouldn't be marked as offline
+	if service.collection != nil {
+		collection := service.collection
+
+		// Only recorded when it can change between scans, in the same write as the document
+		if result.Egress != "" && (len(e.options.Proxies) > 0 || len(e.options.SourceAddrs) > 0) {
+			collection = storage.WithFields(collection, bson.M{"egress": result.Egress})
+		}
+
+		err = service.scanner.Save(ctx, target, latency, response, collection)
+
+		if err != nil {
+			if ctx.Err() == nil {
+				e.log("\nERROR SAVE " + address + ": " + err.Error() + "\n")
+			}
+
+			return true
+		}
+	}
+
+	e.success(result)
+	return true
+}
+
+// Counts the result and passes it to OnResult, stopping the scan if MaxResults is reached
+func (e *Engine) success(result Result) {
+	results := e.results.Add(1)
+
+	if e.options.OnResult != nil {
+		e.options.OnResult(result)
+	}
+
+	if e.options.MaxResults > 0 && results >= e.options.MaxResults {
+		e.stop(fmt.Sprintf("max results (%d) reached", e.options.MaxResults))
+	}
+}
+
+// Stops the scan, only the first reason is kept
+func (e *Engine) stop(reason string) {
+	e.cancel(errors.New(reason))
+}
+
+func (e *Engine) log(message string) {
+	io.WriteString(e.options.Log, message)
+}
+
+// Entries of a rescan that couldn't be read
+func (e *Engine) logRescanError(err error) {
+	e.log("\nERROR RESCAN " + err.Error() + "\n")
+}
+
+// Disconnects from the database and closes the prober and the transport, once the scan ended
+func (e *Engine) release() {
+	if e.prescan != nil {
+		e.prescan.prober.Close()
+	}
+
+	e.transport.Close()
+
+	if e.client == nil {
+		return
+	}
+
+	err := e.client.Disconnect(context.Background())
+	if err != nil {
+		e.log(fmt.Sprintf("failed to disconnect from database: %s\n", err))
+	}
+}
@@ -0,0 +1,587 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Kyagara/hagelslag/proxy"
+	"github.com/Kyagara/hagelslag/proxy/proxytest"
+	"github.com/Kyagara/hagelslag/scanners"
+	"github.com/Kyagara/hagelslag/storage"
+	"github.com/Kyagara/hagelslag/targets"
+)
+
+// Reads the whole response, used without a database
+type loopbackScanner struct{}
+
+func (s loopbackScanner) Name() string {
+	return "loopback"
+}
+
+func (s loopbackScanner) Ports() []uint16 {
+	return []uint16{7}
+}
+
+func (s loopbackScanner) Network() string {
+	return "tcp"
+}
+
+func (s loopbackScanner) Timeouts() scanners.Timeouts {
+	return scanners.Timeouts{Connect: 1 * time.Second, Exchange: 3 * time.Second}
+}
+
+func (s loopbackScanner) Scan(_ context.Context, target netip.AddrPort, conn net.Conn) ([]byte, int64, error) {
+	response, err := scanners.Read(conn, scanners.MAX_RESPONSE_LENGTH)
+	return response, 0, err
+}
+
+func (s loopbackScanner) Save(ctx context.Context, target netip.AddrPort, latency int64, data []byte, collection storage.Store) error {
+	return nil
+}
+
+// Returns the targets in a loop until count targets were returned
+type repeatSource struct {
+	targets []netip.AddrPort
+	count   int64
+
+	next atomic.Int64
+	done atomic.Int64
+}
+
+func (s *repeatSource) Next() (netip.AddrPort, bool) {
+	i := s.next.Add(1) - 1
+	if i >= s.count {
+		return netip.AddrPort{}, false
+	}
+
+	return s.targets[i%int64(len(s.targets))], true
+}
+
+func (s *repeatSource) Done(target netip.AddrPort) {
+	s.done.Add(1)
+}
+
+func (s *repeatSource) Progress() (uint64, uint64) {
+	return uint64(min(s.next.Load(), s.count)), uint64(s.count)
+}
+
+// Listens on the loopback, every connection gets response and is closed
+func listenLoopback(tb testing.TB, response []byte) netip.AddrPort {
+	tb.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	tb.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				conn.Write(response)
+				conn.Close()
+			}()
+		}
+	}()
+
+	return netip.MustParseAddrPort(listener.Addr().String())
+}
+
+// A port that was just closed, connections to it are refused
+func closedLoopbackPort(tb testing.TB) netip.AddrPort {
+	tb.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	target := netip.MustParseAddrPort(listener.Addr().String())
+	listener.Close()
+	return target
+}
+
+// Options scanning source on the loopback, every result is counted in saved
+func loopbackOptions(saved *atomic.Int64, source targets.Source, concurrency int, pipeline bool) Options {
+	return Options{
+		Scanners:        []scanners.Scanner{loopbackScanner{}},
+		Sources:         []targets.Source{source},
+		Concurrency:     concurrency,
+		Pipeline:        pipeline,
+		ScanConcurrency: 2,
+		DrainTimeout:    time.Second,
+		OnResult:        func(Result) { saved.Add(1) },
+	}
+}
+
+func newEngine(tb testing.TB, options Options) *Engine {
+	tb.Helper()
+
+	e, err := New(options)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return e
+}
+
+func TestEngineScansEveryTarget(t *testing.T) {
+	open := listenLoopback(t, []byte("hello"))
+	dead := closedLoopbackPort(t)
+
+	for _, pipeline := range []bool{false, true} {
+		var saved atomic.Int64
+		source := &repeatSource{targets: []netip.AddrPort{open, dead}, count: 20}
+		e := newEngine(t, loopbackOptions(&saved, source, 4, pipeline))
+
+		err := e.Run(context.Background())
+		if err != nil {
+			t.Fatalf("pipeline %t: expected every target to be scanned, got %s", pipeline, err)
+		}
+
+		if source.done.Load() != 20 {
+			t.Fatalf("pipeline %t: expected 20 targets to be done, got %d", pipeline, source.done.Load())
+		}
+
+		if saved.Load() != 10 || e.Stats().Results != 10 {
+			t.Fatalf("pipeline %t: expected 10 results, got %d (stats: %d)", pipeline, saved.Load(), e.Stats().Results)
+		}
+	}
+}
+
+func TestEngineStops(t *testing.T) {
+	open := listenLoopback(t, []byte("hello"))
+
+	var saved atomic.Int64
+	source := &repeatSource{targets: []netip.AddrPort{open}, count: 1 << 40}
+	e := newEngine(t, loopbackOptions(&saved, source, 4, false))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := e.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for saved.Load() < 10 {
+		runtime.Gosched()
+	}
+
+	cancel()
+	<-e.Done()
+
+	if !errors.Is(e.Err(), context.Canceled) {
+		t.Fatalf("expected the scan to be canceled, got %v", e.Err())
+	}
+
+	// Every target taken from the source was either completed or never queued
+	if source.done.Load() > source.next.Load() {
+		t.Fatalf("%d targets done out of %d taken", source.done.Load(), source.next.Load())
+	}
+}
+
+func TestEngineMaxResults(t *testing.T) {
+	open := listenLoopback(t, []byte("hello"))
+
+	var saved atomic.Int64
+	source := &repeatSource{targets: []netip.AddrPort{open}, count: 1 << 40}
+	options := loopbackOptions(&saved, source, 4, false)
+	options.MaxResults = 5
+
+	err := newEngine(t, options).Run(context.Background())
+	if err == nil || err.Error() != "max results (5) reached" {
+		t.Fatalf("expected the max results to stop the scan, got %v", err)
+	}
+
+	if saved.Load() < 5 {
+		t.Fatalf("expected at least 5 results, got %d", saved.Load())
+	}
+}
+
+func TestEngineMaxTargetsStopsStream(t *testing.T) {
+	open := listenLoopback(t, []byte("hello"))
+
+	// Endless reader, only stopping the stream ends it
+	stream := targets.NewStream(0, nil, targets.Exclusions{}, nil)
+	reader := make(chan struct{})
+
+	go func() {
+		defer close(reader)
+		defer stream.Close()
+
+		for stream.Push(open.String()) {
+		}
+	}()
+
+	var saved atomic.Int64
+	options := loopbackOptions(&saved, stream, 4, false)
+	options.MaxTargets = 5
+
+	err := newEngine(t, options).Run(context.Background())
+	if err == nil || err.Error() != "max targets (5) reached" {
+		t.Fatalf("expected the max targets to stop the scan, got %v", err)
+	}
+
+	select {
+	case <-reader:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the reader of the stream to return")
+	}
+}
+
+// Accepts connections on the loopback and never answers, returns the amount accepted
+func listenSilent(tb testing.TB) (netip.AddrPort, *atomic.Int64) {
+	tb.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	var accepted atomic.Int64
+	var mu sync.Mutex
+	var conns []net.Conn
+
+	tb.Cleanup(func() {
+		listener.Close()
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+
+			accepted.Add(1)
+		}
+	}()
+
+	return netip.MustParseAddrPort(listener.Addr().String()), &accepted
+}
+
+func TestEngineDrainDeadline(t *testing.T) {
+	silent, accepted := listenSilent(t)
+
+	for _, pipeline := range []bool{false, true} {
+		var saved atomic.Int64
+		before := accepted.Load()
+		source := &repeatSource{targets: []netip.AddrPort{silent}, count: 1 << 40}
+
+		options := loopbackOptions(&saved, source, 4, pipeline)
+		options.DrainTimeout = 100 * time.Millisecond
+		// Without the drain deadline, the scans would wait for a minute
+		options.Timeouts = scanners.Timeouts{Exchange: time.Minute}
+
+		e := newEngine(t, options)
+		ctx, cancel := context.WithCancel(context.Background())
+
+		err := e.Start(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for accepted.Load()-before < 2 {
+			runtime.Gosched()
+		}
+
+		start := time.Now()
+		cancel()
+
+		select {
+		case <-e.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("pipeline %t: the running scans were not aborted", pipeline)
+		}
+
+		if elapsed := time.Since(start); elapsed < options.DrainTimeout {
+			t.Fatalf("pipeline %t: expected the running scans to get %s, stopped after %s", pipeline, options.DrainTimeout, elapsed)
+		}
+
+		// Aborted scans stay pending
+		if source.done.Load() != 0 {
+			t.Fatalf("pipeline %t: expected no target to be done, got %d", pipeline, source.done.Load())
+		}
+	}
+}
+
+// Loopback scanner with its own name and ports
+type portsScanner struct {
+	loopbackScanner
+	name  string
+	ports []uint16
+}
+
+func (s portsScanner) Name() string {
+	return s.name
+}
+
+func (s portsScanner) Ports() []uint16 {
+	return s.ports
+}
+
+func TestEngineMultipleScanners(t *testing.T) {
+	first := listenLoopback(t, []byte("hello"))
+	second := listenLoopback(t, []byte("hello"))
+
+	set, err := targets.Load([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	found := map[string][]uint16{}
+
+	e := newEngine(t, Options{
+		Scanners: []scanners.Scanner{
+			portsScanner{name: "a", ports: []uint16{first.Port()}},
+			portsScanner{name: "b", ports: []uint16{first.Port(), second.Port()}},
+		},
+		Shares:      []int{1},
+		Targets:     set,
+		Concurrency: 4,
+		OnResult: func(result Result) {
+			mu.Lock()
+			defer mu.Unlock()
+			found[result.Scanner] = append(found[result.Scanner], result.Target.Port())
+		},
+	})
+
+	// Both ports are walked once, the first one is scanned by both scanners
+	if !slices.Equal(e.Ports(), []uint16{first.Port(), second.Port()}) {
+		t.Fatalf("unexpected walked ports %v", e.Ports())
+	}
+
+	if e.services[0].concurrency != 1 || e.services[1].concurrency != 3 {
+		t.Fatalf("expected shares of 1 and 3 workers, got %d and %d", e.services[0].concurrency, e.services[1].concurrency)
+	}
+
+	err = e.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(found["b"])
+	if !slices.Equal(found["a"], []uint16{first.Port()}) || !slices.Equal(found["b"], []uint16{min(first.Port(), second.Port()), max(first.Port(), second.Port())}) {
+		t.Fatalf("unexpected results %v", found)
+	}
+
+	// The target of the first port is only done once both scanners finished it
+	if positions := e.Positions(); positions[0] != 2 {
+		t.Fatalf("expected the walk to be completed, got position %d", positions[0])
+	}
+}
+
+func TestSplitShares(t *testing.T) {
+	tests := []struct {
+		total    int
+		fixed    []int
+		expected []int
+	}{
+		{10, nil, []int{4, 3, 3}},
+		{10, []int{6}, []int{6, 2, 2}},
+		{10, []int{0, 1, 2}, []int{7, 1, 2}},
+		{10, []int{1, 1, 1}, []int{1, 1, 1}},
+	}
+
+	for _, test := range tests {
+		parts, err := split(test.total, test.fixed, 3)
+		if err != nil || !slices.Equal(parts, test.expected) {
+			t.Fatalf("split(%d, %v): expected %v, got %v (%v)", test.total, test.fixed, test.expected, parts, err)
+		}
+	}
+
+	for _, fixed := range [][]int{{9, 0, 0}, {-1}, {1, 1, 1, 1}, {11, 1, 1}} {
+		_, err := split(10, fixed, 3)
+		if err == nil {
+			t.Fatalf("expected %v to be rejected", fixed)
+		}
+	}
+}
+
+// Sends a datagram and returns the answer
+type udpScanner struct {
+	loopbackScanner
+}
+
+func (s udpScanner) Network() string {
+	return "udp"
+}
+
+func (s udpScanner) Timeouts() scanners.Timeouts {
+	return scanners.Timeouts{FirstByte: time.Second}
+}
+
+func (s udpScanner) ScanDatagrams(_ context.Context, target netip.AddrPort, conn scanners.Datagrams) ([]byte, int64, error) {
+	answers, err := conn.Exchange([]byte("ping"), 1)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return answers[0], 0, nil
+}
+
+func TestEngineSharedUDPSockets(t *testing.T) {
+	var list []netip.AddrPort
+	for range 3 {
+		// The first datagram is lost, only a retransmit is answered
+		address, _ := lossyUDPServer(t, 1)
+		list = append(list, netip.MustParseAddrPort(address))
+	}
+
+	var saved atomic.Int64
+	source := &repeatSource{targets: list, count: 30}
+
+	options := loopbackOptions(&saved, source, 8, false)
+	options.Scanners = []scanners.Scanner{udpScanner{}}
+	options.UDPSockets = 2
+	options.Retransmits = 1
+	options.RetryBackoff = 20 * time.Millisecond
+
+	e := newEngine(t, options)
+
+	err := e.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.network.mux == nil {
+		t.Fatal("expected the scans to use the shared sockets")
+	}
+
+	if saved.Load() != 30 || source.done.Load() != 30 {
+		t.Fatalf("expected 30 results, got %d (done: %d)", saved.Load(), source.done.Load())
+	}
+}
+
+func TestEngineSourceAddrs(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	var mu sync.Mutex
+	seen := map[netip.Addr]int{}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			mu.Lock()
+			seen[netip.MustParseAddrPort(conn.RemoteAddr().String()).Addr()]++
+			mu.Unlock()
+
+			conn.Write([]byte("hello"))
+			conn.Close()
+		}
+	}()
+
+	var saved atomic.Int64
+	source := &repeatSource{targets: []netip.AddrPort{netip.MustParseAddrPort(listener.Addr().String())}, count: 10}
+
+	options := loopbackOptions(&saved, source, 2, false)
+	options.SourceAddrs = []netip.Addr{netip.MustParseAddr("127.0.0.2"), netip.MustParseAddr("127.0.0.3")}
+
+	e, err := New(options)
+	if err != nil {
+		t.Skipf("loopback aliases are not available: %s", err)
+	}
+
+	err = e.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Used in turn
+	if saved.Load() != 10 || seen[options.SourceAddrs[0]] != 5 || seen[options.SourceAddrs[1]] != 5 {
+		t.Fatalf("expected 5 connections from each source, got %v (results: %d)", seen, saved.Load())
+	}
+}
+
+func TestEngineRejectsForeignSourceAddrs(t *testing.T) {
+	var saved atomic.Int64
+	options := loopbackOptions(&saved, &repeatSource{}, 1, false)
+	// Documentation range, never assigned to this machine
+	options.SourceAddrs = []netip.Addr{netip.MustParseAddr("192.0.2.1")}
+
+	_, err := New(options)
+	if err == nil {
+		t.Fatal("expected an address of another machine to be rejected")
+	}
+}
+
+func TestEngineProxies(t *testing.T) {
+	open := listenLoopback(t, []byte("hello"))
+	first := proxytest.NewSOCKS5(t, "user", "secret")
+	second := proxytest.NewSOCKS5(t, "", "")
+
+	var mu sync.Mutex
+	egresses := map[string]int{}
+
+	source := &repeatSource{targets: []netip.AddrPort{open}, count: 20}
+	options := loopbackOptions(nil, source, 4, false)
+	options.Proxies = []proxy.Proxy{
+		{Scheme: "socks5", Address: first.Address, Username: "user", Password: "secret", MaxConns: 1},
+		{Scheme: "socks5", Address: second.Address, MaxConns: 1},
+	}
+	options.OnResult = func(result Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		egresses[result.Egress]++
+	}
+
+	err := newEngine(t, options).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if egresses[options.Proxies[0].String()]+egresses[options.Proxies[1].String()] != 20 {
+		t.Fatalf("expected 20 results through the proxies, got %v", egresses)
+	}
+
+	if len(first.Tunnels()) == 0 || len(second.Tunnels()) == 0 {
+		t.Fatalf("expected both proxies to be used, got %d and %d tunnels", len(first.Tunnels()), len(second.Tunnels()))
+	}
+
+	if first.MaxOpen.Load() > 1 || second.MaxOpen.Load() > 1 {
+		t.Fatalf("expected a connection at a time through each proxy, got %d and %d", first.MaxOpen.Load(), second.MaxOpen.Load())
+	}
+
+	options.Scanners = []scanners.Scanner{udpScanner{}}
+	_, err = New(options)
+	if err == nil {
+		t.Fatal("expected udp scanners to be rejected")
+	}
+}
@@ -0,0 +1,275 @@
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Amount of Acquire calls between removing the networks that are not limited anymore
+	POLITENESS_SWEEP_INTERVAL = 4096
+	// How often deferred targets are tried again if no budget was released
+	POLITENESS_RETRY = 10 * time.Millisecond
+	// Deferred targets kept by each producer, the walk waits when there are more
+	MAX_DEFERRED = 65536
+)
+
+// Budgets of concurrent probes and probes per second for every network, a network is the prefix
+// of IPv4Bits (or IPv6Bits) around an address. With an ASN table, the concurrent probes of every ASN
+// are also limited. A nil Politeness doesn't limit anything.
+type Politeness struct {
+	IPv4Bits int
+	IPv6Bits int
+	// Concurrent probes per network, 0 means no limit
+	MaxActive int
+	// Minimum time between two probes to the same network, 0 means no limit
+	Interval time.Duration
+
+	ASNs *ASNTable
+	// Concurrent probes per ASN, 0 means no limit
+	MaxPerASN int
+
+	mu       sync.Mutex
+	networks map[netip.Prefix]*budget
+	asns     map[uint32]int
+	acquired int
+
+	// Notified when a budget is released
+	released chan struct{}
+}
+
+type budget struct {
+	active int
+	// When the next probe to the network can start
+	next time.Time
+}
+
+// rate is the amount of probes per second to the same network, 0 means no limit
+func NewPoliteness(ipv4Bits int, ipv6Bits int, maxActive int, rate float64, asns *ASNTable, maxPerASN int) *Politeness {
+	interval := time.Duration(0)
+	if rate > 0 {
+		interval = time.Duration(float64(time.Second) / rate)
+	}
+
+	return &Politeness{
+		IPv4Bits:  ipv4Bits,
+		IPv6Bits:  ipv6Bits,
+		MaxActive: maxActive,
+		Interval:  interval,
+		ASNs:      asns,
+		MaxPerASN: maxPerASN,
+		networks:  make(map[netip.Prefix]*budget),
+		asns:      make(map[uint32]int),
+		released:  make(chan struct{}, 1),
+	}
+}
+
+// Takes a probe from the budgets of the network and ASN of addr, false if any of them is exhausted
+func (p *Politeness) Acquire(addr netip.Addr) bool {
+	if p == nil {
+		return true
+	}
+
+	return p.acquire(addr, time.Now())
+}
+
+func (p *Politeness) acquire(addr netip.Addr, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.acquired++
+	if p.acquired%POLITENESS_SWEEP_INTERVAL == 0 {
+		p.sweep(now)
+	}
+
+	network := p.network(addr)
+
+	b := p.networks[network]
+	if b == nil {
+		b = &budget{}
+		p.networks[network] = b
+	}
+
+	if p.MaxActive > 0 && b.active >= p.MaxActive {
+		return false
+	}
+
+	if now.Before(b.next) {
+		return false
+	}
+
+	asn, found := p.ASNs.Lookup(addr)
+	if found && p.MaxPerASN > 0 && p.asns[asn] >= p.MaxPerASN {
+		return false
+	}
+
+	b.active++
+	b.next = now.Add(p.Interval)
+
+	if found {
+		p.asns[asn]++
+	}
+
+	return true
+}
+
+// Gives back a probe taken by Acquire
+func (p *Politeness) Release(addr netip.Addr) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+
+	network := p.network(addr)
+	if b := p.networks[network]; b != nil {
+		b.active--
+		if b.active <= 0 && !time.Now().Before(b.next) {
+			delete(p.networks, network)
+		}
+	}
+
+	if asn, found := p.ASNs.Lookup(addr); found {
+		p.asns[asn]--
+		if p.asns[asn] <= 0 {
+			delete(p.asns, asn)
+		}
+	}
+
+	p.mu.Unlock()
+
+	select {
+	case p.released <- struct{}{}:
+	default:
+	}
+}
+
+// Receives when a budget was released, deferred targets may fit now
+func (p *Politeness) Released() <-chan struct{} {
+	if p == nil {
+		return nil
+	}
+
+	return p.released
+}
+
+// Prefix of the network the address belongs to
+func (p *Politeness) network(addr netip.Addr) netip.Prefix {
+	bits := p.IPv6Bits
+	if addr.Is4() {
+		bits = p.IPv4Bits
+	}
+
+	prefix, _ := addr.Prefix(bits)
+	return prefix
+}
+
+// Removes the networks without active probes that can be probed again, must be called with mu held
+func (p *Politeness) sweep(now time.Time) {
+	for network, b := range p.networks {
+		if b.active <= 0 && !now.Before(b.next) {
+			delete(p.networks, network)
+		}
+	}
+}
+
+// Prefix to ASN table, looked up by longest prefix match
+type ASNTable struct {
+	// Prefixes of each length, longest first
+	lengths  []int
+	prefixes map[netip.Prefix]uint32
+}
+
+// Loads a table with a prefix and an ASN per line ('1.0.0.0/24 13335'), separated by spaces or tabs.
+// Empty lines and everything after a '#' are ignored, 'AS' before the number is allowed.
+func LoadASNTable(path string) (*ASNTable, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ASN table: %s", err)
+	}
+
+	defer file.Close()
+
+	table := &ASNTable{prefixes: make(map[netip.Prefix]uint32)}
+	scanner := bufio.NewScanner(file)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		entry, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected a prefix and an ASN", line)
+		}
+
+		prefix, err := netip.ParsePrefix(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid prefix '%s'", line, fields[0])
+		}
+
+		asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(fields[1]), "AS"), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid ASN '%s'", line, fields[1])
+		}
+
+		table.add(prefix, uint32(asn))
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed reading ASN table: %s", err)
+	}
+
+	return table, nil
+}
+
+func (t *ASNTable) add(prefix netip.Prefix, asn uint32) {
+	// Targets are looked up unmapped
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+
+	prefix = prefix.Masked()
+	t.prefixes[prefix] = asn
+
+	if !slices.Contains(t.lengths, prefix.Bits()) {
+		t.lengths = append(t.lengths, prefix.Bits())
+		slices.SortFunc(t.lengths, func(a int, b int) int { return b - a })
+	}
+}
+
+// Returns the ASN of the longest prefix containing addr, a nil table finds nothing
+func (t *ASNTable) Lookup(addr netip.Addr) (uint32, bool) {
+	if t == nil {
+		return 0, false
+	}
+
+	for _, bits := range t.lengths {
+		if bits > addr.BitLen() {
+			continue
+		}
+
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+
+		if asn, found := t.prefixes[prefix]; found {
+			return asn, true
+		}
+	}
+
+	return 0, false
+}
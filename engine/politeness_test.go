@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPolitenessConcurrentPerNetwork(t *testing.T) {
+	p := NewPoliteness(24, 48, 2, 0, nil, 0)
+	now := time.Now()
+
+	first := netip.MustParseAddr("1.2.3.4")
+	second := netip.MustParseAddr("1.2.3.200")
+	other := netip.MustParseAddr("1.2.4.1")
+
+	if !p.acquire(first, now) || !p.acquire(second, now) {
+		t.Fatal("expected 2 probes to fit in the /24")
+	}
+
+	if p.acquire(first, now) {
+		t.Fatal("expected a third probe in the /24 to be deferred")
+	}
+
+	if !p.acquire(other, now) {
+		t.Fatal("expected another /24 to have its own budget")
+	}
+
+	p.Release(second)
+
+	select {
+	case <-p.Released():
+	default:
+		t.Fatal("expected the release to be notified")
+	}
+
+	if !p.acquire(first, now) {
+		t.Fatal("expected a probe to fit after a release")
+	}
+}
+
+func TestPolitenessRatePerNetwork(t *testing.T) {
+	// One probe every 100ms
+	p := NewPoliteness(24, 48, 0, 10, nil, 0)
+	now := time.Now()
+	addr := netip.MustParseAddr("2001:db8::1")
+
+	if !p.acquire(addr, now) {
+		t.Fatal("expected the first probe to fit")
+	}
+
+	if p.acquire(netip.MustParseAddr("2001:db8:0:1::1"), now.Add(50*time.Millisecond)) {
+		t.Fatal("expected a probe to the same /48 50ms later to be deferred")
+	}
+
+	if !p.acquire(addr, now.Add(100*time.Millisecond)) {
+		t.Fatal("expected a probe 100ms later to fit")
+	}
+}
+
+func TestASNTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "asn.txt")
+
+	content := `# prefix asn
+1.0.0.0/8	100
+1.2.0.0/16 AS200
+2001:db8::/32 300
+`
+
+	err := os.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := LoadASNTable(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for addr, expected := range map[string]uint32{"1.1.1.1": 100, "1.2.3.4": 200, "2001:db8::1": 300} {
+		asn, found := table.Lookup(netip.MustParseAddr(addr))
+		if !found || asn != expected {
+			t.Errorf("%s: expected AS%d, got AS%d (found %t)", addr, expected, asn, found)
+		}
+	}
+
+	if _, found := table.Lookup(netip.MustParseAddr("8.8.8.8")); found {
+		t.Error("expected 8.8.8.8 to not have an ASN")
+	}
+
+	// Both /24s are in AS200
+	p := NewPoliteness(24, 48, 0, 0, table, 1)
+	now := time.Now()
+
+	if !p.acquire(netip.MustParseAddr("1.2.3.4"), now) || p.acquire(netip.MustParseAddr("1.2.4.4"), now) {
+		t.Fatal("expected the ASN to only allow one probe")
+	}
+
+	if !p.acquire(netip.MustParseAddr("8.8.8.8"), now) {
+		t.Fatal("expected addresses without an ASN to not be limited by it")
+	}
+}
+
+func TestPolitenessDefersTargets(t *testing.T) {
+	open := listenLoopback(t, []byte("hello"))
+	dead := closedLoopbackPort(t)
+
+	// Every target is in the same /24, they are scanned one at a time but none are dropped
+	var saved atomic.Int64
+	source := &repeatSource{targets: []netip.AddrPort{open, dead}, count: 20}
+	options := loopbackOptions(&saved, source, 8, false)
+	options.MaxPerNetwork = 1
+
+	err := newEngine(t, options).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if source.done.Load() != 20 || saved.Load() != 10 {
+		t.Fatalf("expected 20 targets done and 10 saved, got %d and %d", source.done.Load(), saved.Load())
+	}
+}
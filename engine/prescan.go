@@ -0,0 +1,321 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/Kyagara/hagelslag/targets"
+)
+
+const (
+	// How often the targets waiting for an answer are checked for the end of their wait
+	PRESCAN_SWEEP_INTERVAL = 50 * time.Millisecond
+	// Targets waiting for an answer or for the scan workers, the walk waits when there are more
+	MAX_PRESCAN_WAITING = 1 << 20
+	// Targets that answered and wait for the scan workers, the walk waits when there are more.
+	// The scan workers are behind, the answers of more probes would only pile up.
+	MAX_PRESCAN_READY = 1024
+)
+
+// Sends stateless probes and reports the targets that answered, the SYN prober of the syn package is one
+type Prober interface {
+	// Sends a probe to the target, called from every producer at the same time.
+	// Targets returning errors.ErrUnsupported are queued without a probe.
+	Probe(target netip.AddrPort) error
+	// Targets that answered a probe, the same target can be received more than once
+	Answers() <-chan netip.AddrPort
+	Close() error
+}
+
+// Stage between the producers and the scan workers, only the targets that answered their probe are queued
+type prescan struct {
+	prober Prober
+	// Time a target waits for its answer
+	wait time.Duration
+
+	mu sync.Mutex
+	// Targets probed and not answered yet, the same target can be probed again before the first one ends
+	waiting map[netip.AddrPort][]*pending
+	// Every probe in the order it was sent, their deadlines only increase.
+	// Probes that are not waiting anymore are skipped once their wait ends.
+	order []waiting
+	// Probes in waiting
+	count int
+	// Targets that answered, waiting to be queued for the scan workers
+	ready []*pending
+
+	// Signaled when a target is added to ready
+	readied chan struct{}
+	// Closed once every producer is done, the stage ends when no target is waiting anymore
+	produced chan struct{}
+	// Closed once the answers are not received anymore, nothing is added to ready after it
+	received chan struct{}
+}
+
+type waiting struct {
+	target   netip.AddrPort
+	pending  *pending
+	deadline time.Time
+}
+
+func newPrescan(prober Prober, wait time.Duration) *prescan {
+	return &prescan{
+		prober:   prober,
+		wait:     wait,
+		waiting:  make(map[netip.AddrPort][]*pending),
+		readied:  make(chan struct{}, 1),
+		produced: make(chan struct{}),
+		received: make(chan struct{}),
+	}
+}
+
+// Sends the probe of the target once the rate limiter allows it, returns false if ctx was canceled first.
+// Targets the prober can't probe are queued right away.
+func (e *Engine) probe(ctx context.Context, pending *pending) bool {
+	// Nothing blocks the producers without a rate limit
+	if ctx.Err() != nil || !e.limiter.Wait(ctx.Done()) {
+		e.abort(pending)
+		return false
+	}
+
+	p := e.prescan
+	target := pending.target
+
+	for p.full() {
+		select {
+		case <-time.After(PRESCAN_SWEEP_INTERVAL):
+		case <-ctx.Done():
+			e.abort(pending)
+			return false
+		}
+	}
+
+	// Registered first, the answer can arrive before Probe returns
+	p.mu.Lock()
+	p.waiting[target] = append(p.waiting[target], pending)
+	p.order = append(p.order, waiting{target: target, pending: pending, deadline: time.Now().Add(p.wait)})
+	p.count++
+	p.mu.Unlock()
+
+	err := p.prober.Probe(target)
+	if err == nil {
+		e.sent.Add(1)
+		e.adaptive.Record(nil)
+		return true
+	}
+
+	p.mu.Lock()
+	taken := p.take(target, pending)
+	p.mu.Unlock()
+
+	if !taken {
+		// Answered or expired already
+		return true
+	}
+
+	if !errors.Is(err, errors.ErrUnsupported) {
+		e.adaptive.Record(err)
+		e.log("\nERROR PROBE " + targets.FormatTarget(target) + ": " + err.Error() + "\n")
+	}
+
+	// Scanned without knowing if it answers
+	return e.queue(ctx, pending)
+}
+
+// Removes the pending target from the waiting ones, false if it was not waiting anymore. Called with mu held.
+func (p *prescan) take(target netip.AddrPort, pending *pending) bool {
+	list := p.waiting[target]
+	for i, waiting := range list {
+		if waiting != pending {
+			continue
+		}
+
+		if len(list) == 1 {
+			delete(p.waiting, target)
+		} else {
+			p.waiting[target] = append(list[:i:i], list[i+1:]...)
+		}
+
+		p.count--
+		return true
+	}
+
+	return false
+}
+
+// Receives the answers and completes the targets whose wait ended, until the producers are done and no target
+// is waiting. The targets that answered are handed to dispatch, a full queue never holds back the answers.
+// When ctx is canceled, the waiting targets are aborted and stay pending.
+func (e *Engine) receive(ctx context.Context, work context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	p := e.prescan
+	defer close(p.received)
+
+	sweep := time.NewTicker(PRESCAN_SWEEP_INTERVAL)
+	defer sweep.Stop()
+
+	produced := p.produced
+
+	for {
+		select {
+		case target, ok := <-p.prober.Answers():
+			if !ok {
+				// Nothing can answer anymore
+				e.abortWaiting()
+				return
+			}
+
+			p.mu.Lock()
+			list := p.waiting[target]
+			delete(p.waiting, target)
+			p.count -= len(list)
+			p.ready = append(p.ready, list...)
+			p.mu.Unlock()
+
+			if len(list) > 0 {
+				e.answered.Add(int64(len(list)))
+
+				select {
+				case p.readied <- struct{}{}:
+				default:
+				}
+			}
+
+		case now := <-sweep.C:
+			e.expire(work, now)
+
+		case <-produced:
+			produced = nil
+
+		case <-ctx.Done():
+			e.abortWaiting()
+			return
+		}
+
+		if produced == nil && p.size() == 0 {
+			return
+		}
+	}
+}
+
+// Queues the targets that answered for the scan workers, until the answers are not received anymore and
+// every target was queued. When ctx is canceled, the targets left are aborted and stay pending.
+func (e *Engine) dispatch(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	p := e.prescan
+
+	for {
+		p.mu.Lock()
+		var pending *pending
+		if len(p.ready) > 0 {
+			pending = p.ready[0]
+			p.ready[0] = nil
+			p.ready = p.ready[1:]
+		}
+		p.mu.Unlock()
+
+		if pending != nil {
+			if !e.queue(ctx, pending) {
+				e.abortWaiting()
+				return
+			}
+
+			continue
+		}
+
+		select {
+		case <-p.readied:
+		case <-p.received:
+			// Added before it was closed
+			if p.backlog() == 0 {
+				return
+			}
+		case <-ctx.Done():
+			e.abortWaiting()
+			return
+		}
+	}
+}
+
+// Completes every target whose wait ended before now as not answered
+func (e *Engine) expire(ctx context.Context, now time.Time) {
+	p := e.prescan
+	var expired []*pending
+
+	// Only the probes at the front can be done waiting
+	p.mu.Lock()
+	for len(p.order) > 0 && !now.Before(p.order[0].deadline) {
+		first := p.order[0]
+		p.order[0] = waiting{}
+		p.order = p.order[1:]
+
+		if p.take(first.target, first.pending) {
+			expired = append(expired, first.pending)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, pending := range expired {
+		// Every scanner finishes it, hosts not answering a rescan are marked as offline
+		for _, service := range e.servicesFor(pending.target.Port()) {
+			e.finish(ctx, task{pending: pending, service: service}, false)
+		}
+	}
+}
+
+// Aborts every target waiting for an answer or to be queued, they stay pending
+func (e *Engine) abortWaiting() {
+	p := e.prescan
+
+	p.mu.Lock()
+	aborted := p.ready
+	for _, list := range p.waiting {
+		aborted = append(aborted, list...)
+	}
+
+	clear(p.waiting)
+	p.order = nil
+	p.count = 0
+	p.ready = nil
+	p.mu.Unlock()
+
+	for _, pending := range aborted {
+		e.abort(pending)
+	}
+}
+
+// Amount of probes waiting for an answer
+func (p *prescan) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.count
+}
+
+// Amount of targets that answered and were not queued yet
+func (p *prescan) backlog() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.ready)
+}
+
+// True while no more probes should be sent
+func (p *prescan) full() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.count+len(p.ready) >= MAX_PRESCAN_WAITING || len(p.ready) >= MAX_PRESCAN_READY
+}
+
+// Aborts every task of a target that was never queued, it stays pending
+func (e *Engine) abort(pending *pending) {
+	for range e.servicesFor(pending.target.Port()) {
+		e.complete(pending, true)
+	}
+}
@@ -0,0 +1,43 @@
+//go:build linux
+
+package engine
+
+import (
+	"context"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Kyagara/hagelslag/syn"
+)
+
+// Needs CAP_NET_RAW, run it as root or inside a network namespace: unshare -rn sh -c 'ip link set lo up && go test ./engine'
+func TestSYNPrescanLoopback(t *testing.T) {
+	prober, err := syn.New(netip.MustParseAddr("127.0.0.1"))
+	if err != nil {
+		t.Skipf("raw sockets not available: %s", err)
+	}
+
+	open := listenLoopback(t, []byte("hello"))
+	dead := closedLoopbackPort(t)
+
+	var saved atomic.Int64
+	source := &repeatSource{targets: []netip.AddrPort{open, dead}, count: 20}
+
+	options := loopbackOptions(&saved, source, 4, false)
+	options.Prescan = prober
+	options.PrescanWait = 500 * time.Millisecond
+
+	e := newEngine(t, options)
+
+	err = e.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := e.Stats()
+	if source.done.Load() != 20 || stats.Dialed != stats.Answered || saved.Load() != 10 {
+		t.Fatalf("expected only the open port to be scanned, got %d done, %d results, %+v", source.done.Load(), saved.Load(), stats)
+	}
+}
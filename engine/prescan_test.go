@@ -0,0 +1,190 @@
+package engine
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Answers the probes of the open targets, the others never answer
+type fakeProber struct {
+	open    map[netip.AddrPort]bool
+	answers chan netip.AddrPort
+}
+
+func newFakeProber(open ...netip.AddrPort) *fakeProber {
+	p := &fakeProber{open: make(map[netip.AddrPort]bool), answers: make(chan netip.AddrPort, 64)}
+	for _, target := range open {
+		p.open[target] = true
+	}
+
+	return p
+}
+
+func (p *fakeProber) Probe(target netip.AddrPort) error {
+	if p.open[target] {
+		go func() { p.answers <- target }()
+	}
+
+	return nil
+}
+
+func (p *fakeProber) Answers() <-chan netip.AddrPort {
+	return p.answers
+}
+
+func (p *fakeProber) Close() error {
+	return nil
+}
+
+func TestPrescanOnlyConnectsToAnswers(t *testing.T) {
+	open := listenLoopback(t, []byte("hello"))
+	dead := closedLoopbackPort(t)
+
+	for _, pipeline := range []bool{false, true} {
+		var saved atomic.Int64
+		source := &repeatSource{targets: []netip.AddrPort{open, dead}, count: 20}
+
+		options := loopbackOptions(&saved, source, 4, pipeline)
+		options.Prescan = newFakeProber(open)
+		options.PrescanWait = 50 * time.Millisecond
+
+		e := newEngine(t, options)
+
+		err := e.Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The targets that didn't answer are done without a connection
+		stats := e.Stats()
+		if source.done.Load() != 20 || stats.Sent != 20 || stats.Answered != 10 || stats.Dialed != 10 {
+			t.Fatalf("pipeline %t: expected 20 done, 20 sent, 10 answered and 10 dialed, got %d, %+v", pipeline, source.done.Load(), stats)
+		}
+
+		if saved.Load() != 10 {
+			t.Fatalf("pipeline %t: expected 10 results, got %d", pipeline, saved.Load())
+		}
+	}
+}
+
+func TestPrescanStopKeepsWaitingPending(t *testing.T) {
+	dead := closedLoopbackPort(t)
+
+	var saved atomic.Int64
+	source := &repeatSource{targets: []netip.AddrPort{dead}, count: 1 << 40}
+
+	options := loopbackOptions(&saved, source, 4, false)
+	options.Prescan = newFakeProber()
+	// Nothing expires before the scan is stopped
+	options.PrescanWait = time.Minute
+
+	e := newEngine(t, options)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	err := e.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for e.Stats().Sent < 10 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+
+	select {
+	case <-e.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the waiting targets to be aborted")
+	}
+
+	if source.done.Load() != 0 {
+		t.Fatalf("expected the waiting targets to stay pending, got %d done", source.done.Load())
+	}
+}
+
+// Answers every probe right away, answers that don't fit in the buffer are lost like on a raw socket
+type lossyProber struct {
+	answers chan netip.AddrPort
+	lost    atomic.Int64
+}
+
+func (p *lossyProber) Probe(target netip.AddrPort) error {
+	select {
+	case p.answers <- target:
+	default:
+		p.lost.Add(1)
+	}
+
+	return nil
+}
+
+func (p *lossyProber) Answers() <-chan netip.AddrPort {
+	return p.answers
+}
+
+func (p *lossyProber) Close() error {
+	return nil
+}
+
+// Listens on the loopback, every connection gets response after delay
+func listenSlowLoopback(tb testing.TB, response []byte, delay time.Duration) netip.AddrPort {
+	tb.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	tb.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				time.Sleep(delay)
+				conn.Write(response)
+				conn.Close()
+			}()
+		}
+	}()
+
+	return netip.MustParseAddrPort(listener.Addr().String())
+}
+
+func TestPrescanSlowScannersKeepAnswers(t *testing.T) {
+	// Distinct targets, an answer only covers the probes of its own target
+	open := make([]netip.AddrPort, 32)
+	for i := range open {
+		open[i] = listenSlowLoopback(t, []byte("hello"), 20*time.Millisecond)
+	}
+
+	var saved atomic.Int64
+	source := &repeatSource{targets: open, count: int64(len(open))}
+
+	// A single scan worker is far slower than the probes
+	options := loopbackOptions(&saved, source, 1, false)
+	prober := &lossyProber{answers: make(chan netip.AddrPort, 16)}
+	options.Prescan = prober
+	options.PrescanWait = time.Second
+	options.Rate = 500
+
+	e := newEngine(t, options)
+
+	err := e.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if prober.lost.Load() != 0 || saved.Load() != int64(len(open)) {
+		t.Fatalf("expected every answering host to be scanned, got %d lost answers and %d results", prober.lost.Load(), saved.Load())
+	}
+}
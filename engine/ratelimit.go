@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+// Token bucket limiting how many connection attempts are started per second.
+//
+// Tokens are refilled continuously, the bucket holds at most 10ms worth of tokens so
+// bursts stay small. A nil limiter doesn't limit anything.
+type RateLimiter struct {
+	mu sync.Mutex
+	// Tokens per second
+	rate  float64
+	burst float64
+	// Can be negative, every waiter reserves its token before sleeping
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(rate int) *RateLimiter {
+	burst := max(1, float64(rate)/100)
+
+	return &RateLimiter{
+		rate:   float64(rate),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// Blocks until a token is available, returns false if stop was closed first
+func (l *RateLimiter) Wait(stop <-chan struct{}) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	l.refill()
+
+	l.tokens--
+	if l.tokens >= 0 {
+		l.mu.Unlock()
+		return true
+	}
+
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// Changes the tokens per second, waiters that already reserved a token keep their wait
+func (l *RateLimiter) SetRate(rate int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Tokens until now are added at the previous rate
+	l.refill()
+
+	l.rate = float64(rate)
+	l.burst = max(1, float64(rate)/100)
+	l.tokens = min(l.tokens, l.burst)
+}
+
+// Current tokens per second
+func (l *RateLimiter) Rate() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return int(l.rate)
+}
+
+// Adds the tokens accumulated since the last refill, must be called with mu held
+func (l *RateLimiter) refill() {
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+}
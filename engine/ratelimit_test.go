@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterPace(t *testing.T) {
+	limiter := NewRateLimiter(1000)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for range 30 {
+				if !limiter.Wait(nil) {
+					t.Error("limiter stopped without stop being closed")
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// 300 attempts at 1000/s, minus the initial burst of 10
+	elapsed := time.Since(start)
+	if elapsed < 250*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("expected around 290ms for 300 attempts, took %s", elapsed)
+	}
+}
+
+func TestRateLimiterStop(t *testing.T) {
+	limiter := NewRateLimiter(1)
+
+	// Takes the only token
+	limiter.Wait(nil)
+
+	stop := make(chan struct{})
+	close(stop)
+
+	if limiter.Wait(stop) {
+		t.Fatal("expected the wait to be stopped")
+	}
+
+	var unlimited *RateLimiter
+	if !unlimited.Wait(nil) {
+		t.Fatal("expected a nil limiter to never wait")
+	}
+}
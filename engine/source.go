@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sync/atomic"
+)
+
+// Local addresses the connections are made from, each family used in turn
+type localAddrs struct {
+	v4 []netip.Addr
+	v6 []netip.Addr
+
+	next atomic.Uint64
+}
+
+// Checks that every address can be bound to, nil if there are none
+func newLocalAddrs(addrs []netip.Addr) (*localAddrs, error) {
+	if len(addrs) == 0 {
+		return nil, nil
+	}
+
+	l := &localAddrs{}
+
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+
+		// Fails if the address is not assigned to this machine
+		conn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(addr, 0)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to use source address %s: %s", addr, err)
+		}
+
+		conn.Close()
+
+		if addr.Is4() {
+			l.v4 = append(l.v4, addr)
+		} else {
+			l.v6 = append(l.v6, addr)
+		}
+	}
+
+	return l, nil
+}
+
+// Next address of the family of target, invalid if there is none and the system should choose it
+func (l *localAddrs) pick(target netip.Addr) netip.Addr {
+	if l == nil {
+		return netip.Addr{}
+	}
+
+	addrs := l.v6
+	if target.Unmap().Is4() {
+		addrs = l.v4
+	}
+
+	if len(addrs) == 0 {
+		return netip.Addr{}
+	}
+
+	return addrs[(l.next.Add(1)-1)%uint64(len(addrs))]
+}
+
+// Local address of a dialer of network sending from addr
+func dialerAddr(network string, addr netip.Addr) net.Addr {
+	if network == "udp" {
+		return net.UDPAddrFromAddrPort(netip.AddrPortFrom(addr, 0))
+	}
+
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, 0))
+}
@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"errors"
+	"net"
+	"os"
+	"time"
+
+	"github.com/Kyagara/hagelslag/scanners"
+)
+
+// Connection that sets its own deadlines before every read and write, following the timeouts.
+//
+// With retransmits, a datagram without an answer is written again, waiting backoff for the answer
+// and doubling it after every retransmit. Only used for UDP, where a lost datagram is never resent.
+type deadlineConn struct {
+	net.Conn
+	timeouts scanners.Timeouts
+	start    time.Time
+	// If the first byte of the response was read
+	started bool
+
+	retransmits int
+	backoff     time.Duration
+	// Last datagram written, nil once it was answered
+	last []byte
+}
+
+func newDeadlineConn(conn net.Conn, timeouts scanners.Timeouts, retransmits int, backoff time.Duration) *deadlineConn {
+	return &deadlineConn{
+		Conn:        conn,
+		timeouts:    timeouts,
+		start:       time.Now(),
+		retransmits: retransmits,
+		backoff:     backoff,
+	}
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	err := c.Conn.SetWriteDeadline(c.end())
+	if err != nil {
+		return 0, err
+	}
+
+	if c.retransmits > 0 {
+		c.last = append(c.last[:0], b...)
+	}
+
+	return c.Conn.Write(b)
+}
+
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	deadline := c.readDeadline()
+	retransmits := c.retransmits
+	wait := c.backoff
+
+	for {
+		attempt := deadline
+		if c.last != nil && retransmits > 0 {
+			attempt = earliest(attempt, time.Now().Add(wait))
+		}
+
+		err := c.Conn.SetReadDeadline(attempt)
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := c.Conn.Read(b)
+		if n > 0 || err == nil {
+			c.started = true
+			c.last = nil
+			return n, err
+		}
+
+		// The datagram or its answer was lost, the phase still has time left
+		lost := errors.Is(err, os.ErrDeadlineExceeded) && (deadline.IsZero() || time.Now().Before(deadline))
+		if !lost || c.last == nil || retransmits == 0 {
+			return n, err
+		}
+
+		retransmits--
+		wait *= 2
+
+		_, err = c.Conn.Write(c.last)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// End of the exchange, zero if there is no limit
+func (c *deadlineConn) end() time.Time {
+	if c.timeouts.Exchange <= 0 {
+		return time.Time{}
+	}
+
+	return c.start.Add(c.timeouts.Exchange)
+}
+
+// Earliest of the end of the exchange and the first byte or idle timeout
+func (c *deadlineConn) readDeadline() time.Time {
+	deadline := c.end()
+
+	if !c.started && c.timeouts.FirstByte > 0 {
+		deadline = earliest(deadline, c.start.Add(c.timeouts.FirstByte))
+	}
+
+	if c.started && c.timeouts.Idle > 0 {
+		deadline = earliest(deadline, time.Now().Add(c.timeouts.Idle))
+	}
+
+	return deadline
+}
+
+// Earliest of two deadlines, a zero deadline is no deadline
+func earliest(a time.Time, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+
+	return a
+}
@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Kyagara/hagelslag/scanners"
+)
+
+// Returns the client side of a pipe, the server side is given to serve and closed with the test
+func pipe(t *testing.T, serve func(server net.Conn)) net.Conn {
+	t.Helper()
+
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	go serve(server)
+	return client
+}
+
+func TestDeadlineConnFirstByte(t *testing.T) {
+	client := pipe(t, func(server net.Conn) {})
+	conn := newDeadlineConn(client, scanners.Timeouts{FirstByte: 50 * time.Millisecond, Exchange: 5 * time.Second}, 0, 0)
+
+	start := time.Now()
+	_, err := conn.Read(make([]byte, 1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the first byte timeout, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the read to time out after 50ms, took %s", elapsed)
+	}
+}
+
+func TestDeadlineConnIdle(t *testing.T) {
+	client := pipe(t, func(server net.Conn) {
+		server.Write([]byte("a"))
+	})
+
+	conn := newDeadlineConn(client, scanners.Timeouts{FirstByte: 5 * time.Second, Idle: 50 * time.Millisecond}, 0, 0)
+
+	_, err := conn.Read(make([]byte, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = conn.Read(make([]byte, 1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the idle timeout, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the read to time out after 50ms, took %s", elapsed)
+	}
+}
+
+func TestDeadlineConnExchange(t *testing.T) {
+	// A byte every 10ms never hits the idle timeout
+	client := pipe(t, func(server net.Conn) {
+		for {
+			time.Sleep(10 * time.Millisecond)
+
+			_, err := server.Write([]byte("a"))
+			if err != nil {
+				return
+			}
+		}
+	})
+
+	conn := newDeadlineConn(client, scanners.Timeouts{Exchange: 100 * time.Millisecond, Idle: 5 * time.Second}, 0, 0)
+
+	start := time.Now()
+	_, err := scanners.Read(conn, scanners.MAX_RESPONSE_LENGTH)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the exchange timeout, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the exchange to time out after 100ms, took %s", elapsed)
+	}
+}
+
+// Answers the datagrams after dropping the first drop ones, returns the address and the amount received
+func lossyUDPServer(t *testing.T, drop int64) (string, *atomic.Int64) {
+	t.Helper()
+
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { server.Close() })
+
+	var received atomic.Int64
+	go func() {
+		buffer := make([]byte, 64)
+		for {
+			n, addr, err := server.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+
+			if received.Add(1) > drop {
+				server.WriteTo(buffer[:n], addr)
+			}
+		}
+	}()
+
+	return server.LocalAddr().String(), &received
+}
+
+func TestDeadlineConnRetransmits(t *testing.T) {
+	for _, retransmits := range []int{1, 2} {
+		address, received := lossyUDPServer(t, 2)
+
+		client, err := net.Dial("udp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn := newDeadlineConn(client, scanners.Timeouts{FirstByte: 500 * time.Millisecond}, retransmits, 20*time.Millisecond)
+
+		_, err = conn.Write([]byte("ping"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response := make([]byte, 4)
+		_, err = conn.Read(response)
+		client.Close()
+
+		switch retransmits {
+		case 1:
+			if !errors.Is(err, os.ErrDeadlineExceeded) || received.Load() != 2 {
+				t.Fatalf("expected a timeout after 2 datagrams, got %v after %d", err, received.Load())
+			}
+		case 2:
+			if err != nil || string(response) != "ping" || received.Load() != 3 {
+				t.Fatalf("expected an answer to the third datagram, got %v after %d", err, received.Load())
+			}
+		}
+	}
+}
+
+func TestDialDoesNotRetryRefused(t *testing.T) {
+	e := &Engine{options: Options{Retries: 3, RetryBackoff: time.Second}, transport: &networkTransport{}}
+	target := closedLoopbackPort(t)
+
+	_, err := e.dial(context.Background(), nil, target, "tcp", time.Second)
+	if err == nil {
+		t.Fatal("expected the connection to be refused")
+	}
+
+	if attempts := e.dialed.Load(); attempts != 1 {
+		t.Fatalf("expected a single attempt, got %d", attempts)
+	}
+}
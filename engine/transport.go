@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+	"time"
+
+	"github.com/Kyagara/hagelslag/datagram"
+	"github.com/Kyagara/hagelslag/proxy"
+	"github.com/Kyagara/hagelslag/targets"
+)
+
+// Opens the connections of the scan, every target is dialed through it. The memnet package has one in memory.
+type Transport interface {
+	// Connects to the target over network, 'tcp' or 'udp', called from every worker at the same time.
+	// timeout covers establishing the connection, 0 means no timeout. Over 'udp', every Write of the
+	// connection sends a single datagram and every Read returns a single datagram from the target.
+	Dial(ctx context.Context, network string, target netip.AddrPort, timeout time.Duration) (net.Conn, error)
+	// Called once the scan ended
+	Close() error
+}
+
+// Transport of the network, configured by SourceAddrs, Interface, Proxies and UDPSockets
+type networkTransport struct {
+	// Nil without SourceAddrs
+	local *localAddrs
+	// Binds the sockets to Interface, nil without it
+	control func(network string, address string, c syscall.RawConn) error
+	// Nil without Proxies
+	proxies *proxy.Pool
+	// Shared sockets of the udp scanners, opened by listen, nil without UDPSockets or udp scanners
+	mux *datagram.Mux
+}
+
+// Validates the options of the network transport
+func newNetworkTransport(options Options) (*networkTransport, error) {
+	if options.UDPSockets < 0 {
+		return nil, fmt.Errorf("udp sockets can't be negative")
+	}
+
+	local, err := newLocalAddrs(options.SourceAddrs)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &networkTransport{local: local}
+
+	if options.Interface != "" {
+		t.control, err = bindToInterface(options.Interface)
+		if err != nil {
+			return nil, fmt.Errorf("failed to use interface '%s': %s", options.Interface, err)
+		}
+	}
+
+	if len(options.Proxies) > 0 {
+		// Only TCP can be tunneled
+		for _, scanner := range options.Scanners {
+			if scanner.Network() != "tcp" {
+				return nil, fmt.Errorf("proxies require tcp scanners, '%s' uses %s", scanner.Name(), scanner.Network())
+			}
+		}
+
+		// The answer to a SYN says nothing about what the proxies can reach
+		if options.Prescan != nil {
+			return nil, fmt.Errorf("proxies can't be used with the prescan")
+		}
+
+		t.proxies, err = proxy.NewPool(options.Proxies)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// Opens the shared sockets of the udp scanners
+func (t *networkTransport) listen(sockets int, sources []netip.Addr) error {
+	mux, err := datagram.Listen(datagram.Config{Sockets: sockets, Sources: sources, Control: t.control})
+	if err != nil {
+		return fmt.Errorf("failed to open udp sockets: %s", err)
+	}
+
+	t.mux = mux
+	return nil
+}
+
+func (t *networkTransport) Dial(ctx context.Context, network string, target netip.AddrPort, timeout time.Duration) (net.Conn, error) {
+	// Nothing is sent until the scan, dialing over the shared sockets only waits for one to be free
+	if network == "udp" && t.mux != nil {
+		conn, err := t.mux.Dial(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+
+		return conn, nil
+	}
+
+	dialer := net.Dialer{
+		KeepAlive: -1,
+		Timeout:   timeout,
+		Control:   t.control,
+	}
+
+	// Every dial uses the next source address, a retry doesn't wait for the same ports
+	if source := t.local.pick(target.Addr()); source.IsValid() {
+		dialer.LocalAddr = dialerAddr(network, source)
+	}
+
+	if t.proxies == nil {
+		return dialer.DialContext(ctx, network, targets.FormatTarget(target))
+	}
+
+	// The timeout covers the handshake with the proxy too
+	dialer.Timeout = 0
+
+	conn, err := t.proxies.DialContext(ctx, &dialer, timeout, network, targets.FormatTarget(target))
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (t *networkTransport) Close() error {
+	if t.mux == nil {
+		return nil
+	}
+
+	return t.mux.Close()
+}
@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Kyagara/hagelslag/memnet"
+	"github.com/Kyagara/hagelslag/scanners"
+	"github.com/Kyagara/hagelslag/storage"
+	"github.com/Kyagara/hagelslag/targets"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Fake HTTP host answering every request for path with status and body
+func httpHost(path string, status string, body string) memnet.StreamHandler {
+	return func(conn net.Conn) {
+		request, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil || request.URL.Path != path {
+			return
+		}
+
+		conn.Write([]byte("HTTP/1.1 " + status + "\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body))
+	}
+}
+
+// Fake Veloren server with players online, the first datagram it receives is lost
+func velorenHost(players uint16) memnet.DatagramHandler {
+	var received atomic.Int64
+
+	return func(datagram []byte) [][]byte {
+		if received.Add(1) == 1 || len(datagram) != 263 || string(datagram[256:]) != "veloren" {
+			return nil
+		}
+
+		switch datagram[13] {
+		case 1:
+			// Init answer with the 'P' sent back by the client
+			answer := make([]byte, 14)
+			binary.LittleEndian.PutUint64(answer[4:12], 0xC0FFEE)
+			return [][]byte{answer}
+		case 2:
+			if binary.LittleEndian.Uint64(datagram[2:10]) != 0xC0FFEE {
+				return nil
+			}
+
+			info := make([]byte, 32)
+			binary.BigEndian.PutUint32(info[8:12], 42)
+			binary.BigEndian.PutUint16(info[20:22], players)
+			binary.BigEndian.PutUint16(info[22:24], 64)
+			return [][]byte{info}
+		}
+
+		return nil
+	}
+}
+
+func TestEngineMemoryTransport(t *testing.T) {
+	network := memnet.New()
+	network.HandleTCP(netip.MustParseAddrPort("10.0.0.1:80"), httpHost("/status", "200 OK", "hello"))
+	network.HandleTCP(netip.MustParseAddrPort("10.0.0.2:80"), httpHost("/status", "404 Not Found", "missing"))
+	network.HandleUDP(netip.MustParseAddrPort("10.0.0.3:14006"), velorenHost(7))
+
+	set, err := targets.Load([]string{"10.0.0.0/30"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stores := map[string]*storage.MemoryStore{"http": storage.NewMemoryStore(), "veloren": storage.NewMemoryStore()}
+	var results atomic.Int64
+
+	e := newEngine(t, Options{
+		Scanners:    []scanners.Scanner{scanners.HTTP{Path: "/status"}, scanners.Veloren{}},
+		Targets:     set,
+		Order:       "random",
+		Seed:        1,
+		Concurrency: 4,
+		Transport:   network,
+		Stores:      func(scanner string) storage.Store { return stores[scanner] },
+		// Hosts that don't answer are given up on quickly, the lost datagram is sent again before that
+		Timeouts:     scanners.Timeouts{FirstByte: 200 * time.Millisecond},
+		Retransmits:  1,
+		RetryBackoff: 10 * time.Millisecond,
+		OnResult:     func(Result) { results.Add(1) },
+	})
+
+	err = e.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 4 addresses on the ports of both scanners
+	if stats := e.Stats(); stats.Dialed != 8 || results.Load() != 2 {
+		t.Fatalf("expected 8 targets dialed and 2 results, got %d dialed and %d results", stats.Dialed, results.Load())
+	}
+
+	page := stores["http"].Get("10.0.0.1:80")
+	if stores["http"].Len() != 1 || page == nil || !strings.HasSuffix(page["data"].(string), "hello") {
+		t.Fatalf("expected the page of 10.0.0.1 to be saved, got %v", page)
+	}
+
+	server := stores["veloren"].Get("10.0.0.3:14006")
+	if stores["veloren"].Len() != 1 || server == nil {
+		t.Fatalf("expected the server info of 10.0.0.3 to be saved, got %v", server)
+	}
+
+	info := server["data"].(bson.M)
+	if info["players"] != int32(7) || info["cap"] != int32(64) || info["hash"] != int64(42) {
+		t.Fatalf("unexpected server info %v", info)
+	}
+}
@@ -2,34 +2,23 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
-	"runtime"
-	"strconv"
-	"strings"
-	"sync"
-	"sync/atomic"
 	"syscall"
 	"time"
+
+	"github.com/Kyagara/hagelslag/engine"
+	"github.com/Kyagara/hagelslag/storage"
 )
 
 const (
 	// Format used to print the current status of the program
-	STATUS_FORMAT = "\r\033[KRate: %d | Success: %d | At: %s"
-
-	// 15mb
-	MAX_RESPONSE_LENGTH = 15 * 1024 * 1024
-
-	// 256kb
-	INITIAL_BUFFER_SIZE = 256 * 1024
-)
-
-var (
-	// Amount of times the scanner connect (if OnlyConnect is true) or saved to the database successfully
-	SUCCESS = int64(0)
-	// For use when going to log an error but the program is shutting down
-	SHUTTING_DOWN = false
+	STATUS_FORMAT = "\r\033[KRate: %d/s (limit: %s) | Success: %d | Progress: %d/%d (%.2f%%)"
+	// Status when running as a pipeline, with the stats of both stages
+	PIPELINE_STATUS_FORMAT = "\r\033[KConnect: %d/%d open, %d/s (limit: %s) | Scan: %d/%d saved, %d queued | Progress: %d/%d (%.2f%%)"
 )
 
 func main() {
@@ -39,6 +28,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	defer hagelslag.Close()
+
+	e, err := engine.New(hagelslag.Options)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	options := e.Options()
+
 	writer := bufio.NewWriter(os.Stderr)
 	defer writer.Flush()
 
@@ -47,87 +46,140 @@ func main() {
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 
-	semaphore := make(chan struct{}, hagelslag.Rate)
-	addresses := make(chan string)
+	if options.Order == "random" {
+		fmt.Printf("Seed: %d\n", options.Seed)
+	}
+
+	fmt.Printf("Run ID: %s\n", hagelslag.RunID)
 
-	var wg sync.WaitGroup
-	for range runtime.NumCPU() {
-		wg.Add(1)
-		go hagelslag.worker(addresses, semaphore, &wg)
+	// Rescans can't be resumed, there is nothing to save
+	var checkpoint <-chan time.Time
+	if !options.Rescanning() {
+		checkpoint = time.NewTicker(hagelslag.CheckpointInterval).C
 	}
 
-	ip, port, err := getStartingIPAndPort(hagelslag.StartingIP, hagelslag.Port)
+	// Canceled with the reason when shutting down, no new scan is started after it
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	// Done is closed when all targets were scanned, a limit was reached or after ctx is canceled and the running scans are done
+	err = e.Start(ctx)
 	if err != nil {
 		fmt.Println(err)
 		writer.Flush()
 		os.Exit(1)
 	}
 
+	// Prints why the scan stopped and saves a checkpoint it can be resumed from
+	stopped := func() {
+		stats := e.Stats()
+		fmt.Printf("Stopped: %s\n", e.Err())
+		fmt.Printf("Progress: %d/%d\n", stats.Position, stats.Total)
+		printStages(options, stats)
+
+		if options.Rescanning() {
+			return
+		}
+
+		// Workers are done, every pending target is either completed or was never started
+		err := storage.SaveCheckpoint(hagelslag.CheckpointPath, hagelslag.Checkpoint(e, false))
+		if err != nil {
+			fmt.Printf("Failed to save checkpoint: %s\n", err)
+		} else {
+			fmt.Printf("Resume with: -resume %s\n", hagelslag.CheckpointPath)
+		}
+	}
+
+	lastAttempts := int64(0)
+
 	// Main loop
 	for {
 		select {
 		// Print status every second
 		case <-status:
-			success := atomic.LoadInt64(&SUCCESS)
-			fmt.Fprintf(writer, STATUS_FORMAT, hagelslag.Rate, success, parseAddress(ip, port))
-			writer.Flush()
+			stats := e.Stats()
+			percentage := float64(stats.Position) / float64(max(stats.Total, 1)) * 100
 
-		// Handle SIGINT and SIGTERM signals
-		case <-signals:
-			fmt.Printf("\nShutting down...\n")
+			// Changes over time with -adaptive
+			rateLimit := "none"
+			if stats.Rate > 0 {
+				rateLimit = fmt.Sprintf("%d/s", stats.Rate)
+			}
 
-			SHUTTING_DOWN = true
-			close(addresses)
-			wg.Wait()
+			// Connection attempts in the last second, or SYNs with the prescan
+			attempts := stats.Dialed
+			if options.Prescan != nil {
+				attempts = stats.Sent
+			}
 
-			address := parseAddress(ip, port)
-			if strings.HasPrefix(address, "255.0.0.0") {
-				fmt.Println("Done.")
+			rate := attempts - lastAttempts
+			lastAttempts = attempts
+
+			if options.Pipeline {
+				fmt.Fprintf(writer, PIPELINE_STATUS_FORMAT, stats.Connected, stats.Dialed, rate, rateLimit, stats.Results, stats.Scanned, stats.Queued, stats.Position, stats.Total, percentage)
 			} else {
-				fmt.Printf("Last IP: %s\n", address)
+				fmt.Fprintf(writer, STATUS_FORMAT, rate, rateLimit, stats.Results, stats.Position, stats.Total, percentage)
 			}
 
-			return
+			writer.Flush()
+
+		// Save the progress in case the process crashes
+		case <-checkpoint:
+			err := storage.SaveCheckpoint(hagelslag.CheckpointPath, hagelslag.Checkpoint(e, false))
+			if err != nil {
+				os.Stderr.WriteString("\nERROR CHECKPOINT: " + err.Error() + "\n")
+			}
 
-		// Increment the IP
-		default:
-			// Skip 255.x.x.x
-			if ip >= 0xFF000000 {
-				signals <- syscall.SIGTERM
-				continue
+		// All targets were scanned or a limit was reached
+		case <-e.Done():
+			if e.Err() != nil {
+				fmt.Println()
+				stopped()
+				return
 			}
 
-			if isReserved(&ip) {
-				os.Stderr.WriteString("\nReserved range reached, skipping to next available range.\n")
+			if hagelslag.EndIP.IsValid() {
+				fmt.Printf("\nDone, end IP %s reached.\n", hagelslag.EndIP)
+			} else {
+				fmt.Printf("\nDone.\n")
 			}
 
-			address := parseAddress(ip, port)
+			printStages(options, e.Stats())
+
+			if options.Rescanning() {
+				return
+			}
 
-			// Get a slot to work on a task
-			semaphore <- struct{}{}
+			err := storage.SaveCheckpoint(hagelslag.CheckpointPath, hagelslag.Checkpoint(e, true))
+			if err != nil {
+				os.Stderr.WriteString("\nERROR CHECKPOINT: " + err.Error() + "\n")
+			}
 
-			// Send the address to workers
-			addresses <- address
+			return
 
-			ip++
+		// Handle SIGINT and SIGTERM signals
+		case <-signals:
+			fmt.Printf("\nShutting down, waiting up to %s for the running scans...\n", options.DrainTimeout)
+			cancel(errors.New("signal received"))
+			<-e.Done()
+			stopped()
+			return
 		}
 	}
 }
 
-func getStartingIPAndPort(ip string, port string) (uint32, uint16, error) {
-	ipUint, err := parseIP(ip)
-	if err != nil {
-		return 0, 0, fmt.Errorf("failed parsing starting IP: %s", err)
+// Summary of the prescan and each stage of the pipeline
+func printStages(options engine.Options, stats engine.Stats) {
+	if options.Prescan != nil {
+		fmt.Printf("Prescan: %d sent, %d answered\n", stats.Sent, stats.Answered)
 	}
 
-	portInt, err := strconv.Atoi(port)
-	if err != nil {
-		return 0, 0, fmt.Errorf("failed parsing port: %s", err)
+	if !options.Pipeline {
+		return
 	}
 
-	portUint := uint16(portInt)
-
-	return ipUint, portUint, nil
+	fmt.Printf("Connect: %d tried, %d open\n", stats.Dialed, stats.Connected)
+	fmt.Printf("Scan: %d scanned, %d saved\n", stats.Scanned, stats.Results)
 }
 
 // ************#*#******####*########***#####################%%%%@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@%%@@@@%%%@@@@@@@@@@@@@@@@@@@
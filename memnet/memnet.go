@@ -0,0 +1,127 @@
+package memnet
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Kyagara/hagelslag/datagram"
+)
+
+// Serves a connection to a fake TCP host, the connection is closed when it returns.
+// The connection is a net.Pipe, every write waits for the other side to read it.
+type StreamHandler func(conn net.Conn)
+
+// Answers a datagram sent to a fake UDP host, none drops it. The returned datagrams are received in order,
+// the ones past datagram.QUEUE_LENGTH not read yet are lost. Called from the Write of the datagram.
+type DatagramHandler func(datagram []byte) [][]byte
+
+// Network in memory where every host is a handler, it can be used as the Transport of the engine.
+// Connections to a TCP address without a handler are refused, datagrams to a UDP address without one are lost.
+type Network struct {
+	mu        sync.RWMutex
+	streams   map[netip.AddrPort]StreamHandler
+	datagrams map[netip.AddrPort]DatagramHandler
+}
+
+func New() *Network {
+	return &Network{
+		streams:   make(map[netip.AddrPort]StreamHandler),
+		datagrams: make(map[netip.AddrPort]DatagramHandler),
+	}
+}
+
+// Serves the TCP connections to target with handler, replacing the previous one
+func (n *Network) HandleTCP(target netip.AddrPort, handler StreamHandler) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.streams[unmap(target)] = handler
+}
+
+// Answers the datagrams sent to target with handler, replacing the previous one
+func (n *Network) HandleUDP(target netip.AddrPort, handler DatagramHandler) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.datagrams[unmap(target)] = handler
+}
+
+// Connects to the handler of target, the timeout is not used since nothing waits
+func (n *Network) Dial(ctx context.Context, network string, target netip.AddrPort, timeout time.Duration) (net.Conn, error) {
+	target = unmap(target)
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	switch network {
+	case "tcp":
+		handler, ok := n.streams[target]
+		if !ok {
+			return nil, &net.OpError{Op: "dial", Net: network, Addr: net.TCPAddrFromAddrPort(target), Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+		}
+
+		client, server := net.Pipe()
+
+		go func() {
+			defer server.Close()
+			handler(server)
+		}()
+
+		return &pipeConn{Conn: client, remote: net.TCPAddrFromAddrPort(target)}, nil
+
+	case "udp":
+		// Nothing answers a datagram to an unknown host, like the network
+		return newDatagramConn(target, n.datagrams[target]), nil
+	}
+
+	return nil, net.UnknownNetworkError(network)
+}
+
+// Nothing to release
+func (n *Network) Close() error {
+	return nil
+}
+
+func unmap(target netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(target.Addr().Unmap(), target.Port())
+}
+
+// Client side of the pipe to a fake host, reporting its address
+type pipeConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// Connection to a fake UDP host, the handler answers every Write before it returns
+func newDatagramConn(target netip.AddrPort, handler DatagramHandler) *datagram.Conn {
+	var conn *datagram.Conn
+
+	send := func(b []byte) (int, error) {
+		if handler == nil {
+			return len(b), nil
+		}
+
+		for _, answer := range handler(append([]byte(nil), b...)) {
+			conn.Deliver(answer)
+		}
+
+		return len(b), nil
+	}
+
+	// Unknown local address, like a socket bound to every address
+	conn = datagram.NewConn(&net.UDPAddr{}, target, send, nil)
+	return conn
+}
@@ -0,0 +1,106 @@
+package memnet
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	n := New()
+	target := netip.MustParseAddrPort("192.0.2.1:80")
+
+	n.HandleTCP(target, func(conn net.Conn) {
+		request := make([]byte, 4)
+		io.ReadFull(conn, request)
+		conn.Write(append([]byte("echo "), request...))
+	})
+
+	conn, err := n.Dial(context.Background(), "tcp", target, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	if conn.RemoteAddr().String() != target.String() {
+		t.Fatalf("unexpected remote address %s", conn.RemoteAddr())
+	}
+
+	conn.Write([]byte("ping"))
+
+	// The handler closes the connection once it returns
+	response, err := io.ReadAll(conn)
+	if err != nil || string(response) != "echo ping" {
+		t.Fatalf("unexpected response %q (%v)", response, err)
+	}
+
+	_, err = n.Dial(context.Background(), "tcp", netip.MustParseAddrPort("192.0.2.2:80"), time.Second)
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("expected the connection to be refused, got %v", err)
+	}
+}
+
+func TestDatagram(t *testing.T) {
+	n := New()
+	target := netip.MustParseAddrPort("192.0.2.1:53")
+
+	received := 0
+	n.HandleUDP(target, func(datagram []byte) [][]byte {
+		received++
+
+		// The first one is lost
+		if received == 1 {
+			return nil
+		}
+
+		return [][]byte{[]byte("first"), append([]byte("second "), datagram...)}
+	})
+
+	conn, err := n.Dial(context.Background(), "udp", target, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+	buf := make([]byte, 32)
+
+	conn.Write([]byte("ping"))
+	conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+
+	_, err = conn.Read(buf)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+
+	conn.Write([]byte("ping"))
+	conn.SetReadDeadline(time.Time{})
+
+	// Every datagram is read on its own
+	for _, expected := range []string{"first", "second ping"} {
+		size, err := conn.Read(buf)
+		if err != nil || string(buf[:size]) != expected {
+			t.Fatalf("expected %q, got %q (%v)", expected, buf[:size], err)
+		}
+	}
+
+	// Unknown hosts never answer
+	lost, err := n.Dial(context.Background(), "udp", netip.MustParseAddrPort("192.0.2.2:53"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lost.Write([]byte("ping"))
+	time.AfterFunc(20*time.Millisecond, func() { lost.Close() })
+
+	_, err = lost.Read(buf)
+	if !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Connects through an HTTP proxy with the CONNECT method, sending Basic credentials when set
+type HTTPConnect struct {
+	Proxy   Proxy
+	Forward Dialer
+}
+
+func (h *HTTPConnect) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	var tunneled net.Conn
+
+	_, err := tunnel(ctx, h.Forward, h.Proxy, network, address, func(conn net.Conn) error {
+		request := "CONNECT " + address + " HTTP/1.1\r\nHost: " + address + "\r\n"
+		if h.Proxy.Username != "" {
+			credentials := base64.StdEncoding.EncodeToString([]byte(h.Proxy.Username + ":" + h.Proxy.Password))
+			request += "Proxy-Authorization: Basic " + credentials + "\r\n"
+		}
+
+		_, err := conn.Write([]byte(request + "\r\n"))
+		if err != nil {
+			return err
+		}
+
+		reader := bufio.NewReader(conn)
+		response, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+		if err != nil {
+			return err
+		}
+
+		// The body of a failed CONNECT is not drained, the connection is closed instead
+		if response.StatusCode < 200 || response.StatusCode > 299 {
+			if refusedResponse(response) {
+				return &RefusedError{Reason: fmt.Sprintf("%s answered the CONNECT with '%s'", h.Proxy, response.Status)}
+			}
+
+			return fmt.Errorf("%s answered the CONNECT with '%s'", h.Proxy, response.Status)
+		}
+
+		response.Body.Close()
+
+		tunneled = conn
+
+		// The target can talk first, what was read past the response is not lost
+		if reader.Buffered() > 0 {
+			tunneled = &bufferedConn{Conn: conn, reader: reader}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tunneled, nil
+}
+
+// A 502 or 503 only counts as refused when it says so, other failures of the proxy can be retried.
+// Squid names the errno of the failed connect in a header.
+func refusedResponse(response *http.Response) bool {
+	if response.StatusCode != http.StatusBadGateway && response.StatusCode != http.StatusServiceUnavailable {
+		return false
+	}
+
+	if strings.Contains(strings.ToLower(response.Status), "refused") {
+		return true
+	}
+
+	squid := strings.Fields(response.Header.Get("X-Squid-Error"))
+	return len(squid) == 2 && squid[0] == "ERR_CONNECT_FAIL" && squid[1] == "111"
+}
+
+// Connection reading what was buffered first
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Proxies used in turn, the connections open through each one are limited to its MaxConns
+type Pool struct {
+	proxies []Proxy
+	// Proxy the next dial starts looking from
+	next atomic.Uint64
+
+	mu sync.Mutex
+	// Connections open through each proxy
+	open []int
+	// Closed and replaced every time a connection is closed, wakes up the dials waiting for a proxy
+	released chan struct{}
+}
+
+// Connection through a proxy of a Pool, closing it frees its place
+type Conn struct {
+	net.Conn
+	egress    string
+	release   func()
+	closeOnce sync.Once
+}
+
+func NewPool(proxies []Proxy) (*Pool, error) {
+	if len(proxies) == 0 {
+		return nil, errors.New("at least one proxy is needed")
+	}
+
+	return &Pool{
+		proxies:  proxies,
+		open:     make([]int, len(proxies)),
+		released: make(chan struct{}),
+	}, nil
+}
+
+// Dials address through the next proxy below its limit, waiting while every one is at its limit.
+// forward connects to the proxies. timeout covers connecting to the proxy and the handshake,
+// not the wait for a proxy, 0 means no timeout.
+func (p *Pool) DialContext(ctx context.Context, forward Dialer, timeout time.Duration, network string, address string) (*Conn, error) {
+	i, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	conn, err := p.proxies[i].Dialer(forward).DialContext(ctx, network, address)
+	if err != nil {
+		p.release(i)
+		return nil, err
+	}
+
+	return &Conn{Conn: conn, egress: p.proxies[i].String(), release: func() { p.release(i) }}, nil
+}
+
+// Takes a place of the next proxy below its limit, returns its index
+func (p *Pool) acquire(ctx context.Context) (int, error) {
+	start := p.next.Add(1) - 1
+
+	for {
+		p.mu.Lock()
+		for offset := range p.proxies {
+			i := int((start + uint64(offset)) % uint64(len(p.proxies)))
+
+			if p.proxies[i].MaxConns == 0 || p.open[i] < p.proxies[i].MaxConns {
+				p.open[i]++
+				p.mu.Unlock()
+				return i, nil
+			}
+		}
+
+		released := p.released
+		p.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+func (p *Pool) release(i int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.open[i]--
+	close(p.released)
+	p.released = make(chan struct{})
+}
+
+// Proxy the connection goes through
+func (c *Conn) Egress() string {
+	return c.egress
+}
+
+func (c *Conn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(c.release)
+	return err
+}
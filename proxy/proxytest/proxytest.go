@@ -0,0 +1,208 @@
+package proxytest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+)
+
+// Proxy running in the process and listening on the loopback, closed when the test ends
+type Server struct {
+	Address string
+	// Tunnels open at the same time, the current amount and the highest one
+	Open    atomic.Int64
+	MaxOpen atomic.Int64
+
+	listener net.Listener
+
+	mu sync.Mutex
+	// Targets the tunnels were opened to, in order
+	targets []string
+}
+
+// SOCKS5 proxy asking for the username and password when username is set
+func NewSOCKS5(tb testing.TB, username string, password string) *Server {
+	return listen(tb, func(s *Server, conn net.Conn, reader *bufio.Reader) {
+		greeting := make([]byte, 2)
+		if _, err := io.ReadFull(reader, greeting); err != nil {
+			return
+		}
+
+		methods := make([]byte, greeting[1])
+		if _, err := io.ReadFull(reader, methods); err != nil {
+			return
+		}
+
+		if username == "" {
+			conn.Write([]byte{5, 0})
+		} else {
+			conn.Write([]byte{5, 2})
+
+			header := make([]byte, 2)
+			if _, err := io.ReadFull(reader, header); err != nil {
+				return
+			}
+
+			user := make([]byte, header[1])
+			io.ReadFull(reader, user)
+
+			size, _ := reader.ReadByte()
+			pass := make([]byte, size)
+			io.ReadFull(reader, pass)
+
+			if string(user) != username || string(pass) != password {
+				conn.Write([]byte{1, 1})
+				return
+			}
+
+			conn.Write([]byte{1, 0})
+		}
+
+		request := make([]byte, 4)
+		if _, err := io.ReadFull(reader, request); err != nil {
+			return
+		}
+
+		var host string
+		switch request[3] {
+		case 1, 4:
+			addr := make([]byte, 4)
+			if request[3] == 4 {
+				addr = make([]byte, 16)
+			}
+
+			io.ReadFull(reader, addr)
+			ip, _ := netip.AddrFromSlice(addr)
+			host = ip.String()
+		case 3:
+			size, _ := reader.ReadByte()
+			name := make([]byte, size)
+			io.ReadFull(reader, name)
+			host = string(name)
+		}
+
+		port := make([]byte, 2)
+		if _, err := io.ReadFull(reader, port); err != nil {
+			return
+		}
+
+		target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+
+		upstream, err := net.Dial("tcp", target)
+		if err != nil {
+			// Connection refused
+			conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		s.relay(target, conn, reader, upstream)
+	})
+}
+
+// HTTP proxy answering CONNECT requests
+func NewHTTPConnect(tb testing.TB) *Server {
+	return listen(tb, func(s *Server, conn net.Conn, reader *bufio.Reader) {
+		request, err := http.ReadRequest(reader)
+		if err != nil {
+			return
+		}
+
+		if request.Method != http.MethodConnect {
+			conn.Write([]byte("HTTP/1.1 405 Method Not Allowed\r\n\r\n"))
+			return
+		}
+
+		upstream, err := net.Dial("tcp", request.Host)
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			conn.Write([]byte("HTTP/1.1 502 Connection refused\r\n\r\n"))
+			return
+		}
+
+		if err != nil {
+			conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+			return
+		}
+
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		s.relay(request.Host, conn, reader, upstream)
+	})
+}
+
+func listen(tb testing.TB, handle func(s *Server, conn net.Conn, reader *bufio.Reader)) *Server {
+	tb.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	s := &Server{Address: listener.Addr().String(), listener: listener}
+	tb.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				handle(s, conn, bufio.NewReader(conn))
+			}()
+		}
+	}()
+
+	return s
+}
+
+// Copies both ways between the client and the target until one of them closes
+func (s *Server) relay(target string, conn net.Conn, reader *bufio.Reader, upstream net.Conn) {
+	defer upstream.Close()
+
+	s.mu.Lock()
+	s.targets = append(s.targets, target)
+	s.mu.Unlock()
+
+	open := s.Open.Add(1)
+	defer s.Open.Add(-1)
+
+	for {
+		highest := s.MaxOpen.Load()
+		if open <= highest || s.MaxOpen.CompareAndSwap(highest, open) {
+			break
+		}
+	}
+
+	done := make(chan struct{}, 2)
+
+	go func() {
+		io.Copy(upstream, reader)
+		done <- struct{}{}
+	}()
+
+	go func() {
+		io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+
+	<-done
+}
+
+// Targets the tunnels were opened to
+func (s *Server) Tunnels() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.targets...)
+}
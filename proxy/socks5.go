@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+const (
+	SOCKS_VERSION = 5
+
+	SOCKS_NO_AUTH       = 0x00
+	SOCKS_PASSWORD_AUTH = 0x02
+	SOCKS_NO_METHOD     = 0xFF
+
+	SOCKS_CONNECT = 0x01
+	// Reply of a CONNECT refused by the target
+	SOCKS_REFUSED = 0x05
+
+	SOCKS_IPV4   = 0x01
+	SOCKS_DOMAIN = 0x03
+	SOCKS_IPV6   = 0x04
+)
+
+// Reasons of the failed replies of a SOCKS5 proxy, by reply code
+var SOCKS_ERRORS = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// Connects through a SOCKS5 proxy (RFC 1928), authenticating with a username and password (RFC 1929) when set
+type SOCKS5 struct {
+	Proxy   Proxy
+	Forward Dialer
+}
+
+func (s *SOCKS5) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	request, err := socksRequest(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return tunnel(ctx, s.Forward, s.Proxy, network, address, func(conn net.Conn) error {
+		err := s.authenticate(conn)
+		if err != nil {
+			return err
+		}
+
+		_, err = conn.Write(request)
+		if err != nil {
+			return err
+		}
+
+		return socksReply(conn)
+	})
+}
+
+// Negotiates the authentication method and authenticates if the proxy asks for it
+func (s *SOCKS5) authenticate(conn net.Conn) error {
+	methods := []byte{SOCKS_VERSION, 1, SOCKS_NO_AUTH}
+	if s.Proxy.Username != "" {
+		methods = []byte{SOCKS_VERSION, 2, SOCKS_NO_AUTH, SOCKS_PASSWORD_AUTH}
+	}
+
+	_, err := conn.Write(methods)
+	if err != nil {
+		return err
+	}
+
+	chosen := make([]byte, 2)
+	_, err = io.ReadFull(conn, chosen)
+	if err != nil {
+		return err
+	}
+
+	if chosen[0] != SOCKS_VERSION {
+		return fmt.Errorf("%s is not a SOCKS5 proxy", s.Proxy)
+	}
+
+	switch chosen[1] {
+	case SOCKS_NO_AUTH:
+		return nil
+	case SOCKS_PASSWORD_AUTH:
+		if s.Proxy.Username == "" {
+			break
+		}
+
+		if len(s.Proxy.Username) > 255 || len(s.Proxy.Password) > 255 {
+			return errors.New("SOCKS5 username and password can't be longer than 255 bytes")
+		}
+
+		auth := []byte{1, byte(len(s.Proxy.Username))}
+		auth = append(auth, s.Proxy.Username...)
+		auth = append(auth, byte(len(s.Proxy.Password)))
+		auth = append(auth, s.Proxy.Password...)
+
+		_, err = conn.Write(auth)
+		if err != nil {
+			return err
+		}
+
+		status := make([]byte, 2)
+		_, err = io.ReadFull(conn, status)
+		if err != nil {
+			return err
+		}
+
+		if status[1] != 0 {
+			return fmt.Errorf("%s rejected the username and password", s.Proxy)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("%s accepts none of the authentication methods", s.Proxy)
+}
+
+// CONNECT request to address, IP addresses are sent as is and hostnames for the proxy to resolve
+func socksRequest(address string) ([]byte, error) {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port '%s'", portString)
+	}
+
+	request := []byte{SOCKS_VERSION, SOCKS_CONNECT, 0}
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addr = addr.Unmap()
+		if addr.Is4() {
+			request = append(request, SOCKS_IPV4)
+		} else {
+			request = append(request, SOCKS_IPV6)
+		}
+
+		request = append(request, addr.AsSlice()...)
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("hostname '%s' is too long", host)
+		}
+
+		request = append(request, SOCKS_DOMAIN, byte(len(host)))
+		request = append(request, host...)
+	}
+
+	return binary.BigEndian.AppendUint16(request, uint16(port)), nil
+}
+
+// Reads the reply to the CONNECT request, the tunnel is open after a successful one
+func socksReply(conn net.Conn) error {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(conn, header)
+	if err != nil {
+		return err
+	}
+
+	if header[1] != 0 {
+		reason, ok := SOCKS_ERRORS[header[1]]
+		if !ok {
+			reason = fmt.Sprintf("unknown reply %d", header[1])
+		}
+
+		if header[1] == SOCKS_REFUSED {
+			return &RefusedError{Reason: "SOCKS5 connect failed: " + reason}
+		}
+
+		return errors.New("SOCKS5 connect failed: " + reason)
+	}
+
+	// Address the proxy connects from, not used
+	length := 0
+	switch header[3] {
+	case SOCKS_IPV4:
+		length = 4
+	case SOCKS_IPV6:
+		length = 16
+	case SOCKS_DOMAIN:
+		size := make([]byte, 1)
+		_, err = io.ReadFull(conn, size)
+		if err != nil {
+			return err
+		}
+
+		length = int(size[0])
+	default:
+		return fmt.Errorf("unknown SOCKS5 address type %d", header[3])
+	}
+
+	_, err = io.ReadFull(conn, make([]byte, length+2))
+	return err
+}
@@ -0,0 +1,119 @@
+package scanners
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/Kyagara/hagelslag/storage"
+	"github.com/Kyagara/hagelslag/targets"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type HTTP struct {
+	// Path requested, default: /
+	Path string
+}
+
+func init() {
+	options := HTTP{Path: "/"}
+
+	RegisterScanner("http", func() (Scanner, error) {
+		if !strings.HasPrefix(options.Path, "/") {
+			return nil, fmt.Errorf("path '%s' must start with '/'", options.Path)
+		}
+
+		return options, nil
+	}, Info{
+		Description: "Sends a GET request, saves the response if the status is 2xx",
+		Flags: func(flags *flag.FlagSet) {
+			flags.StringVar(&options.Path, "http-path", options.Path, "Path requested by the http scanner (default: /)")
+		},
+	})
+}
+
+func (s HTTP) Name() string {
+	return "http"
+}
+
+func (s HTTP) Network() string {
+	return "tcp"
+}
+
+func (s HTTP) Ports() []uint16 {
+	return []uint16{80}
+}
+
+func (s HTTP) Timeouts() Timeouts {
+	// Pages can be large, the body is read until the end of the exchange
+	return Timeouts{Connect: 1 * time.Second, FirstByte: 3 * time.Second, Exchange: 10 * time.Second, Idle: 3 * time.Second}
+}
+
+func (s HTTP) Scan(_ context.Context, target netip.AddrPort, conn net.Conn) ([]byte, int64, error) {
+	// IPv6 addresses are enclosed in brackets
+	host := targets.FormatTarget(target)
+
+	path := s.Path
+	if path == "" {
+		path = "/"
+	}
+
+	request := []string{"GET ", path, " HTTP/1.1\r\nHost: ", host, "\r\nConnection: close\r\n\r\n"}
+	get := strings.Join(request, "")
+
+	start := time.Now()
+	_, err := conn.Write(unsafe.Slice(unsafe.StringData(get), len(get)))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	response := make([]byte, 17)
+
+	_, err = io.ReadFull(conn, response)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	latency := time.Since(start).Milliseconds()
+
+	// Check if the status code is 2xx.
+	if response[9] != '2' {
+		return nil, 0, nil
+	}
+
+	response, err = Read(conn, MAX_RESPONSE_LENGTH)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return response, latency, nil
+}
+
+func (s HTTP) Save(ctx context.Context, target netip.AddrPort, latency int64, data []byte, collection storage.Store) error {
+	address := targets.FormatTarget(target)
+
+	document := bson.M{
+		"_id":       address,
+		"port":      target.Port(),
+		"latency":   latency,
+		"last_seen": time.Now(),
+		"data":      *(*string)(unsafe.Pointer(&data)),
+	}
+
+	filter := bson.M{"_id": address}
+	opts := options.Replace().SetUpsert(true)
+
+	_, err := collection.ReplaceOne(ctx, filter, document, opts)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -1,20 +1,29 @@
-package main
+package scanners
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
 	"time"
 	"unsafe"
 
+	"github.com/Kyagara/hagelslag/storage"
+	"github.com/Kyagara/hagelslag/targets"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type Minecraft struct{}
 
+func init() {
+	RegisterScanner("minecraft", func() (Scanner, error) { return Minecraft{}, nil }, Info{
+		Description: "Sends a handshake and a status request, saves the server list ping response",
+	})
+}
+
 func (s Minecraft) Name() string {
 	return "minecraft"
 }
@@ -23,13 +32,18 @@ func (s Minecraft) Network() string {
 	return "tcp"
 }
 
-func (s Minecraft) Port() string {
-	return "25565"
+func (s Minecraft) Ports() []uint16 {
+	return []uint16{25565}
 }
 
-func (s Minecraft) Scan(ip string, conn net.Conn) ([]byte, int64, error) {
-	// Handshake
-	hostLen := len(ip)
+func (s Minecraft) Timeouts() Timeouts {
+	return Timeouts{Connect: 1 * time.Second, FirstByte: 3 * time.Second, Exchange: 5 * time.Second, Idle: 2 * time.Second}
+}
+
+func (s Minecraft) Scan(_ context.Context, target netip.AddrPort, conn net.Conn) ([]byte, int64, error) {
+	// Handshake, IPv6 addresses are sent without brackets
+	host := target.Addr().String()
+	hostLen := len(host)
 	packetLen := 7 + hostLen
 
 	request := make([]byte, packetLen+1)
@@ -40,13 +54,11 @@ func (s Minecraft) Scan(ip string, conn net.Conn) ([]byte, int64, error) {
 	request[4] = byte(hostLen)
 
 	i := 5
-	copy(request[i:], ip)
+	copy(request[i:], host)
 	i += hostLen
 
-	request[i] = byte(255)
-	i++
-	request[i] = byte(65)
-	i++
+	binary.BigEndian.PutUint16(request[i:], target.Port())
+	i += 2
 
 	request[i] = 1
 
@@ -107,10 +119,14 @@ func (s Minecraft) Scan(ip string, conn net.Conn) ([]byte, int64, error) {
 	return response, latency, nil
 }
 
-func (s Minecraft) Save(ip string, latency int64, data []byte, collection *mongo.Collection) error {
+func (s Minecraft) Save(ctx context.Context, target netip.AddrPort, latency int64, data []byte, collection storage.Store) error {
+	address := targets.FormatTarget(target)
+
 	document := bson.M{
-		"_id":     ip,
-		"latency": latency,
+		"_id":       address,
+		"port":      target.Port(),
+		"latency":   latency,
+		"last_seen": time.Now(),
 	}
 
 	var result bson.D
@@ -120,12 +136,12 @@ func (s Minecraft) Save(ip string, latency int64, data []byte, collection *mongo
 		document["data"] = *(*string)(unsafe.Pointer(&data))
 	}
 
-	filter := bson.M{"_id": ip}
+	filter := bson.M{"_id": address}
 	opts := options.Replace().SetUpsert(true)
 
-	_, err = collection.ReplaceOne(context.TODO(), filter, document, opts)
+	_, err = collection.ReplaceOne(ctx, filter, document, opts)
 	if err != nil {
-		return fmt.Errorf("failed to insert document '%s': %s", ip, err)
+		return fmt.Errorf("failed to insert document '%s': %s", address, err)
 	}
 
 	return nil
@@ -0,0 +1,125 @@
+package scanners
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Creates a scanner, called once the flags of the scanner were parsed
+type Factory func() (Scanner, error)
+
+// Metadata of a registered scanner
+type Info struct {
+	// One line shown by -list-scanners
+	Description string
+	// Defines the flags of the scanner, their names should start with the name of the scanner.
+	// The factory reads their values, it is called with the defaults if the flags are never defined.
+	// Called again on a separate set to list them, defaults should be the current values.
+	Flags func(flags *flag.FlagSet)
+}
+
+// Registered scanner, as listed by Registered
+type Registration struct {
+	Name        string
+	Description string
+	Network     string
+	Ports       []uint16
+	// Flags of the scanner with their defaults, empty if it has none
+	Flags []*flag.Flag
+
+	info    Info
+	factory Factory
+}
+
+var (
+	registryMutex sync.RWMutex
+	// Scanners by name, filled by RegisterScanner
+	registry = map[string]Registration{}
+)
+
+// Adds a scanner to the registry, usually from the init function of the package implementing it.
+// Names are case insensitive, registering the same name twice panics.
+func RegisterScanner(name string, factory Factory, info Info) {
+	name = strings.ToLower(name)
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if factory == nil {
+		panic("scanners: RegisterScanner factory is nil for " + name)
+	}
+
+	if _, ok := registry[name]; ok {
+		panic("scanners: RegisterScanner called twice for " + name)
+	}
+
+	registry[name] = Registration{Name: name, Description: info.Description, info: info, factory: factory}
+}
+
+// Creates the scanner registered with name
+func New(name string) (Scanner, error) {
+	registryMutex.RLock()
+	registration, ok := registry[strings.ToLower(name)]
+	registryMutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown scanner '%s'", name)
+	}
+
+	scanner, err := registration.factory()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scanner '%s': %s", name, err)
+	}
+
+	return scanner, nil
+}
+
+// Defines the flags of every registered scanner in flags
+func DefineFlags(flags *flag.FlagSet) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	for _, registration := range registry {
+		if registration.info.Flags != nil {
+			registration.info.Flags(flags)
+		}
+	}
+}
+
+// Every registered scanner sorted by name, the network and ports are the ones of the scanner created by the factory
+func Registered() ([]Registration, error) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	registrations := make([]Registration, 0, len(registry))
+
+	for _, registration := range registry {
+		scanner, err := registration.factory()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create scanner '%s': %s", registration.Name, err)
+		}
+
+		registration.Network = scanner.Network()
+		registration.Ports = scanner.Ports()
+
+		// Defined in a separate set, only to list them
+		if registration.info.Flags != nil {
+			flags := flag.NewFlagSet(registration.Name, flag.ContinueOnError)
+			registration.info.Flags(flags)
+			flags.VisitAll(func(f *flag.Flag) {
+				registration.Flags = append(registration.Flags, f)
+			})
+		}
+
+		registrations = append(registrations, registration)
+	}
+
+	slices.SortFunc(registrations, func(a Registration, b Registration) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return registrations, nil
+}
@@ -0,0 +1,166 @@
+package scanners
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Kyagara/hagelslag/storage"
+)
+
+const (
+	// 15mb
+	MAX_RESPONSE_LENGTH = 15 * 1024 * 1024
+
+	// 256kb
+	INITIAL_BUFFER_SIZE = 256 * 1024
+)
+
+type Scanner interface {
+	// Name of the scanner
+	Name() string
+	// Default ports to connect to
+	Ports() []uint16
+	// 'tcp' or 'udp', the scanners using 'udp' implement DatagramScanner
+	Network() string
+	// Default timeouts of each phase, the ones set in the engine options override them
+	Timeouts() Timeouts
+	// Responsible for sending and receiving all the necessary data for saving.
+	// The connection is closed when ctx is canceled.
+	Scan(ctx context.Context, target netip.AddrPort, conn net.Conn) ([]byte, int64, error)
+	// Saves the response to the database
+	Save(ctx context.Context, target netip.AddrPort, latency int64, data []byte, collection storage.Store) error
+}
+
+// Scanner exchanging datagrams with the target, the engine calls ScanDatagrams instead of Scan.
+// Scan is still used when the scanner is given a connected socket of its own.
+type DatagramScanner interface {
+	Scanner
+	// Responsible for exchanging all the datagrams necessary for saving.
+	// The exchange is aborted when ctx is canceled.
+	ScanDatagrams(ctx context.Context, target netip.AddrPort, conn Datagrams) ([]byte, int64, error)
+}
+
+// Datagrams exchanged with a target, the socket can be shared with other scans
+type Datagrams interface {
+	// Sends request as a single datagram and returns the datagrams answered, at most limit. Returns once limit
+	// datagrams were received or, after the first one, when the next one doesn't arrive in time.
+	// The request is sent again while nothing answers, following the retransmits of the engine.
+	Exchange(request []byte, limit int) ([][]byte, error)
+}
+
+// Timeouts of each phase of a scan, 0 means no timeout for that phase
+type Timeouts struct {
+	// Establishing the connection
+	Connect time.Duration
+	// From the start of the exchange until the first byte of the response
+	FirstByte time.Duration
+	// The whole exchange, from the first write to the last read
+	Exchange time.Duration
+	// Between two reads once the response started
+	Idle time.Duration
+}
+
+// Returns t with the phases set in override replaced
+func (t Timeouts) Override(override Timeouts) Timeouts {
+	if override.Connect > 0 {
+		t.Connect = override.Connect
+	}
+
+	if override.FirstByte > 0 {
+		t.FirstByte = override.FirstByte
+	}
+
+	if override.Exchange > 0 {
+		t.Exchange = override.Exchange
+	}
+
+	if override.Idle > 0 {
+		t.Idle = override.Idle
+	}
+
+	return t
+}
+
+// Buffers used by Read, reused between scans instead of allocating one per scan
+var readBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, INITIAL_BUFFER_SIZE)
+		return &buf
+	},
+}
+
+// Exchanges datagrams over conn, every Write of it sends a single datagram and every Read returns a single datagram
+func NewDatagrams(conn net.Conn) Datagrams {
+	return datagramConn{conn}
+}
+
+type datagramConn struct {
+	conn net.Conn
+}
+
+func (c datagramConn) Exchange(request []byte, limit int) ([][]byte, error) {
+	_, err := c.conn.Write(request)
+	if err != nil {
+		return nil, err
+	}
+
+	pooled := readBuffers.Get().(*[]byte)
+	defer readBuffers.Put(pooled)
+	buf := *pooled
+
+	var answers [][]byte
+	for len(answers) < limit {
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			// The answers already received are enough
+			if len(answers) > 0 && errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+
+			return nil, err
+		}
+
+		answers = append(answers, append([]byte(nil), buf[:n]...))
+	}
+
+	return answers, nil
+}
+
+// Reads from a connection until the internal buffer reaches limit or EOF is encountered.
+func Read(conn net.Conn, limit int) ([]byte, error) {
+	var response []byte
+
+	pooled := readBuffers.Get().(*[]byte)
+	defer readBuffers.Put(pooled)
+	buf := *pooled
+
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			if len(response)+n > limit {
+				// Trim to fit the limit and append
+				response = append(response, buf[:limit-len(response)]...)
+				break
+			}
+
+			// Append the read data to the buffer
+			response = append(response, buf[:n]...)
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return response, nil
+}
@@ -0,0 +1,298 @@
+package scanners
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kyagara/hagelslag/targets"
+)
+
+// Listens on the IPv6 loopback and runs handle for the first connection
+func listenIPv6(t *testing.T, handle func(conn net.Conn)) netip.AddrPort {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %s", err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(3 * time.Second))
+		handle(conn)
+	}()
+
+	return netip.MustParseAddrPort(listener.Addr().String())
+}
+
+func dialTarget(t *testing.T, target netip.AddrPort) net.Conn {
+	t.Helper()
+
+	conn, err := net.DialTimeout("tcp", targets.FormatTarget(target), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	return conn
+}
+
+func TestHTTPScanIPv6(t *testing.T) {
+	hosts := make(chan string, 1)
+
+	target := listenIPv6(t, func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			line = strings.TrimSpace(line)
+			if line == "" {
+				break
+			}
+
+			if host, found := strings.CutPrefix(line, "Host: "); found {
+				hosts <- host
+			}
+		}
+
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello")
+	})
+
+	response, _, err := HTTP{}.Scan(context.Background(), target, dialTarget(t, target))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host := <-hosts
+	if host != fmt.Sprintf("[::1]:%d", target.Port()) {
+		t.Fatalf("unexpected Host header '%s'", host)
+	}
+
+	if !bytes.HasSuffix(response, []byte("hello")) {
+		t.Fatalf("unexpected response '%s'", response)
+	}
+}
+
+func TestMinecraftScanIPv6(t *testing.T) {
+	type handshake struct {
+		host string
+		port uint16
+	}
+
+	handshakes := make(chan handshake, 1)
+	status := `{"description":"hagelslag"}`
+
+	target := listenIPv6(t, func(conn net.Conn) {
+		s := Minecraft{}
+
+		length, err := s.readVarInt(conn)
+		if err != nil {
+			return
+		}
+
+		packet := make([]byte, length)
+		_, err = io.ReadFull(conn, packet)
+		if err != nil {
+			return
+		}
+
+		// Packet ID, 2 bytes of protocol version, host length
+		hostLen := int(packet[3])
+		host := string(packet[4 : 4+hostLen])
+		port := binary.BigEndian.Uint16(packet[4+hostLen:])
+		handshakes <- handshake{host: host, port: port}
+
+		// Status request
+		request := make([]byte, 2)
+		_, err = io.ReadFull(conn, request)
+		if err != nil {
+			return
+		}
+
+		response := append([]byte{byte(len(status) + 2), 0x0, byte(len(status))}, status...)
+		conn.Write(response)
+	})
+
+	response, _, err := Minecraft{}.Scan(context.Background(), target, dialTarget(t, target))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := <-handshakes
+	if received.host != "::1" || received.port != target.Port() {
+		t.Fatalf("unexpected handshake address '%s' port %d", received.host, received.port)
+	}
+
+	if string(response) != status {
+		t.Fatalf("unexpected response '%s'", response)
+	}
+}
+
+func TestTimeoutsOverride(t *testing.T) {
+	defaults := HTTP{}.Timeouts()
+	timeouts := defaults.Override(Timeouts{Connect: 4 * time.Second})
+
+	if timeouts.Connect != 4*time.Second || timeouts.Exchange != defaults.Exchange {
+		t.Fatalf("expected only the connect timeout to change, got %+v", timeouts)
+	}
+}
+
+// Scanner registered by the tests, greeting is set by its flag
+type greetingScanner struct {
+	HTTP
+	greeting string
+}
+
+func TestRegistry(t *testing.T) {
+	options := greetingScanner{greeting: "hello"}
+
+	t.Cleanup(func() {
+		registryMutex.Lock()
+		defer registryMutex.Unlock()
+		delete(registry, "greeting")
+	})
+
+	RegisterScanner("Greeting", func() (Scanner, error) { return options, nil }, Info{
+		Description: "Used by the tests",
+		Flags: func(flags *flag.FlagSet) {
+			flags.StringVar(&options.greeting, "greeting-text", options.greeting, "Sent by the greeting scanner")
+		},
+	})
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	DefineFlags(flags)
+
+	err := flags.Parse([]string{"-greeting-text", "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scanner, err := New("GREETING")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if scanner.(greetingScanner).greeting != "hi" {
+		t.Fatalf("expected the flag to be used by the factory, got '%s'", scanner.(greetingScanner).greeting)
+	}
+
+	_, err = New("unknown")
+	if err == nil {
+		t.Fatal("expected an unknown scanner to be rejected")
+	}
+
+	registrations, err := Registered()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, len(registrations))
+	for i, registration := range registrations {
+		names[i] = registration.Name
+	}
+
+	if !slices.Equal(names, []string{"greeting", "http", "minecraft", "veloren"}) {
+		t.Fatalf("unexpected scanners %v", names)
+	}
+
+	if registrations[1].Network != "tcp" || !slices.Equal(registrations[1].Ports, []uint16{80}) || len(registrations[1].Flags) != 1 {
+		t.Fatalf("unexpected http registration %+v", registrations[1])
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected registering the same name twice to panic")
+		}
+	}()
+
+	RegisterScanner("greeting", func() (Scanner, error) { return options, nil }, Info{})
+}
+
+func TestDatagramsExchange(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// Every write of the pipe is read as a whole, like a datagram
+	go func() {
+		request := make([]byte, 64)
+		n, err := server.Read(request)
+		if err != nil {
+			return
+		}
+
+		server.Write(request[:n])
+		server.Write([]byte("second"))
+	}()
+
+	// Only two answers arrive, the deadline ends the wait for a third one
+	client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+
+	answers, err := NewDatagrams(client).Exchange([]byte("first"), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(answers) != 2 || string(answers[0]) != "first" || string(answers[1]) != "second" {
+		t.Fatalf("expected the two answers, got %q", answers)
+	}
+}
+
+// Answers the requests of a Veloren scan with the init and server info responses
+type velorenServer struct {
+	requests [][]byte
+}
+
+func (s *velorenServer) Exchange(request []byte, limit int) ([][]byte, error) {
+	s.requests = append(s.requests, append([]byte(nil), request...))
+
+	if request[13] == 1 {
+		init := make([]byte, 14)
+		binary.LittleEndian.PutUint64(init[4:12], 0xC0FFEE)
+		return [][]byte{init}, nil
+	}
+
+	info := make([]byte, 32)
+	binary.BigEndian.PutUint16(info[20:22], 7)
+	return [][]byte{info}, nil
+}
+
+func TestVelorenScanDatagrams(t *testing.T) {
+	server := &velorenServer{}
+
+	response, _, err := Veloren{}.ScanDatagrams(context.Background(), netip.MustParseAddrPort("192.0.2.1:14006"), server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server info request carries the 'P' of the init response
+	if len(server.requests) != 2 || binary.LittleEndian.Uint64(server.requests[1][2:10]) != 0xC0FFEE {
+		t.Fatalf("unexpected requests %v", server.requests)
+	}
+
+	if len(response) != 32 || binary.BigEndian.Uint16(response[20:22]) != 7 {
+		t.Fatalf("expected the server info, got %v", response)
+	}
+}
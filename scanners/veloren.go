@@ -0,0 +1,129 @@
+package scanners
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/Kyagara/hagelslag/storage"
+	"github.com/Kyagara/hagelslag/targets"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Veloren struct{}
+
+func init() {
+	RegisterScanner("veloren", func() (Scanner, error) { return Veloren{}, nil }, Info{
+		Description: "Sends an init packet and a server info request over UDP, saves the server info",
+	})
+}
+
+func (s Veloren) Name() string {
+	return "veloren"
+}
+
+func (s Veloren) Network() string {
+	return "udp"
+}
+
+func (s Veloren) Ports() []uint16 {
+	return []uint16{14006}
+}
+
+func (s Veloren) Timeouts() Timeouts {
+	// Every read waits for a single datagram, lost ones are retransmitted
+	return Timeouts{FirstByte: 2 * time.Second, Exchange: 5 * time.Second, Idle: 2 * time.Second}
+}
+
+func (s Veloren) Scan(ctx context.Context, target netip.AddrPort, conn net.Conn) ([]byte, int64, error) {
+	return s.ScanDatagrams(ctx, target, NewDatagrams(conn))
+}
+
+func (s Veloren) ScanDatagrams(_ context.Context, _ netip.AddrPort, conn Datagrams) ([]byte, int64, error) {
+	request := make([]byte, 263)
+	request[13] = 1
+	header := []byte{'v', 'e', 'l', 'o', 'r', 'e', 'n'}
+	for i, v := range header {
+		request[256+i] = v
+	}
+
+	start := time.Now()
+
+	// Init request
+	answers, err := conn.Exchange(request, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	latency := time.Since(start).Milliseconds()
+
+	response := answers[0]
+	if len(response) < 14 {
+		return nil, 0, nil
+	}
+
+	p := binary.LittleEndian.Uint64(response[4:12])
+
+	// version, not used for now
+	// v := binary.BigEndian.Uint16(response[12:])
+
+	// Set 'P' from response to the request
+	binary.LittleEndian.PutUint64(request[2:12], p)
+
+	// Change request to server info
+	request[13] = 2
+
+	// Server info request
+	answers, err = conn.Exchange(request, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	response = answers[0]
+	if len(response) < 32 {
+		return nil, 0, nil
+	}
+
+	return response[:32], latency, nil
+}
+
+func (s Veloren) Save(ctx context.Context, target netip.AddrPort, latency int64, data []byte, collection storage.Store) error {
+	address := targets.FormatTarget(target)
+
+	type serverInfo struct {
+		Hash       uint32
+		Timestamp  uint64
+		Players    uint16
+		Cap        uint16
+		BattleMode uint8
+	}
+
+	info := serverInfo{
+		Hash:       binary.BigEndian.Uint32(data[8:12]),
+		Timestamp:  binary.BigEndian.Uint64(data[12:20]),
+		Players:    binary.BigEndian.Uint16(data[20:22]),
+		Cap:        binary.BigEndian.Uint16(data[22:24]),
+		BattleMode: data[24],
+	}
+
+	document := bson.M{
+		"_id":       address,
+		"port":      target.Port(),
+		"latency":   latency,
+		"last_seen": time.Now(),
+		"data":      info,
+	}
+
+	filter := bson.M{"_id": address}
+	opts := options.Replace().SetUpsert(true)
+
+	_, err := collection.ReplaceOne(ctx, filter, document, opts)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
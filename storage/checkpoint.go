@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// State of a scan, enough to resume it from where it stopped
+type Checkpoint struct {
+	RunID string `json:"run_id"`
+	// Comma separated names of the scanners
+	Scanner string `json:"scanner"`
+	// Scan workers of each scanner, parallel to the names, 0 is an even part of what is left
+	Shares []int `json:"shares,omitempty"`
+
+	Targets []string `json:"targets"`
+	// Used to detect if the targets changed since the checkpoint was written
+	TargetsSize     uint64   `json:"targets_size"`
+	Exclude         []string `json:"exclude"`
+	ExcludeReserved bool     `json:"exclude_reserved"`
+	Ports           []uint16 `json:"ports"`
+	// Each scanner used its own ports, Ports are the ones of every scanner
+	ScannerPorts bool   `json:"scanner_ports,omitempty"`
+	PortOrder    string `json:"port_order"`
+
+	Order string `json:"order"`
+	Seed  uint64 `json:"seed"`
+	Shard string `json:"shard"`
+	EndIP string `json:"end_ip,omitempty"`
+	// Low-water mark of each sub-shard, every step of the walk before it was completed
+	Positions []uint64 `json:"positions"`
+
+	Success   int64     `json:"success"`
+	Done      bool      `json:"done"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Writes the checkpoint to a temporary file and renames it to path, a crash never leaves a partial checkpoint behind
+func SaveCheckpoint(path string, checkpoint Checkpoint) error {
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+func LoadCheckpoint(path string) (Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+
+	var checkpoint Checkpoint
+	err = json.Unmarshal(data, &checkpoint)
+	if err != nil {
+		return Checkpoint{}, fmt.Errorf("invalid checkpoint: %s", err)
+	}
+
+	if checkpoint.Done {
+		return Checkpoint{}, fmt.Errorf("scan '%s' already finished", checkpoint.RunID)
+	}
+
+	if len(checkpoint.Positions) == 0 {
+		return Checkpoint{}, fmt.Errorf("invalid checkpoint: no positions")
+	}
+
+	return checkpoint, nil
+}
+
+// Scanners in the format of -scanner, with the workers of the ones that had a share ('http:200,minecraft')
+func (c Checkpoint) ScannerSpec() string {
+	names := strings.Split(c.Scanner, ",")
+
+	for i := range names {
+		if i < len(c.Shares) && c.Shares[i] > 0 {
+			names[i] += ":" + strconv.Itoa(c.Shares[i])
+		}
+	}
+
+	return strings.Join(names, ",")
+}
+
+// Random identifier of a scan, kept when resuming
+func NewRunID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
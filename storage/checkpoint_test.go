@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	checkpoint := Checkpoint{
+		RunID:     NewRunID(),
+		Scanner:   "http,minecraft",
+		Shares:    []int{0, 200},
+		Targets:   []string{"1.0.0.0/8", "hitlist.txt"},
+		Ports:     []uint16{25565, 25566},
+		PortOrder: "host",
+		Order:     "random",
+		Seed:      1234,
+		Shard:     "1/4",
+		Positions: []uint64{10, 20, 30},
+		Success:   42,
+	}
+
+	err := SaveCheckpoint(path, checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.RunID != checkpoint.RunID || loaded.Seed != checkpoint.Seed || !slices.Equal(loaded.Positions, checkpoint.Positions) {
+		t.Fatalf("expected %+v, got %+v", checkpoint, loaded)
+	}
+
+	// Resumed with the same workers for each scanner
+	if loaded.ScannerSpec() != "http,minecraft:200" {
+		t.Fatalf("expected the share of minecraft to be kept, got '%s'", loaded.ScannerSpec())
+	}
+
+	checkpoint.Done = true
+	err = SaveCheckpoint(path, checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = LoadCheckpoint(path)
+	if err == nil {
+		t.Fatal("expected a finished scan to not be resumable")
+	}
+}
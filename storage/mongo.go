@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// Name of the database, every scanner saves to its own collection in it
+const DATABASE = "hagelslag"
+
+// Connects to the database and checks that it is reachable
+func Connect(ctx context.Context, uri string) (*mongo.Client, error) {
+	options := options.Client().
+		ApplyURI(uri).
+		SetWriteConcern(&writeconcern.WriteConcern{})
+
+	client, err := mongo.Connect(ctx, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %s", err)
+	}
+
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		client.Disconnect(context.Background())
+		return nil, fmt.Errorf("failed to ping database: %s", err)
+	}
+
+	return client, nil
+}
+
+// Collection the results of a scanner are saved to
+func Collection(client *mongo.Client, scanner string) *mongo.Collection {
+	return client.Database(DATABASE).Collection(scanner)
+}
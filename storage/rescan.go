@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/Kyagara/hagelslag/targets"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// How long connecting, counting and querying the documents can take before the rescan gives up
+const STREAM_SETUP_TIMEOUT = 10 * time.Second
+
+// Streams the '_id' of every document in the collection matching the filter, documents that can't be read are passed to onError.
+// Fails if the database can't be reached within STREAM_SETUP_TIMEOUT.
+func StreamCollection(ctx context.Context, uri string, name string, filter bson.M, ports []uint16, excluded targets.Exclusions, onError func(error)) (*targets.Stream, error) {
+	setup, cancel := context.WithTimeout(ctx, STREAM_SETUP_TIMEOUT)
+	defer cancel()
+
+	client, err := Connect(setup, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	collection := Collection(client, name)
+
+	total, err := collection.CountDocuments(setup, filter)
+	if err != nil {
+		client.Disconnect(context.Background())
+		return nil, fmt.Errorf("failed to count documents: %s", err)
+	}
+
+	cursor, err := collection.Find(setup, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		client.Disconnect(context.Background())
+		return nil, fmt.Errorf("failed to query documents: %s", err)
+	}
+
+	s := targets.NewStream(uint64(total), ports, excluded, onError)
+
+	go func() {
+		defer s.Close()
+		defer client.Disconnect(context.Background())
+		defer cursor.Close(context.Background())
+
+		// Stopping the stream interrupts the cursor
+		for cursor.Next(s.Context()) {
+			var document struct {
+				ID string `bson:"_id"`
+			}
+
+			err := cursor.Decode(&document)
+			if err != nil {
+				s.Report(fmt.Errorf("failed to decode '%s': %s", cursor.Current.String(), err))
+				s.Skip()
+				continue
+			}
+
+			if !s.Push(document.ID) {
+				return
+			}
+		}
+
+		err := cursor.Err()
+		if err != nil && s.Context().Err() == nil {
+			s.Report(fmt.Errorf("failed to read '%s': %s", name, err))
+		}
+	}()
+
+	return s, nil
+}
+
+// Documents to rescan, the ones matching filter that were not seen since olderThan, 0 matches every document.
+// Documents saved before 'last_seen' existed are always rescanned.
+func RescanFilter(olderThan time.Duration, filter bson.M) bson.M {
+	if filter == nil {
+		filter = bson.M{}
+	}
+
+	if olderThan <= 0 {
+		return filter
+	}
+
+	stale := bson.M{"$or": []bson.M{
+		{"last_seen": bson.M{"$lt": time.Now().Add(-olderThan)}},
+		{"last_seen": bson.M{"$exists": false}},
+	}}
+
+	return bson.M{"$and": []bson.M{filter, stale}}
+}
+
+// Marks the document of a host that stopped answering, saving it again removes the mark.
+// Only the first time it was found offline is kept.
+func MarkOffline(ctx context.Context, target netip.AddrPort, collection Store) error {
+	address := targets.FormatTarget(target)
+
+	filter := bson.M{"_id": address, "offline_since": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"offline_since": time.Now()}}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to mark '%s' as offline: %s", address, err)
+	}
+
+	return nil
+}
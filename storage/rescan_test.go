@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Kyagara/hagelslag/targets"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRescanFilter(t *testing.T) {
+	filter := RescanFilter(0, nil)
+	if len(filter) != 0 {
+		t.Fatalf("expected an empty filter, got %v", filter)
+	}
+
+	custom := bson.M{"port": 25565}
+	filter = RescanFilter(7*24*time.Hour, custom)
+
+	and, ok := filter["$and"].([]bson.M)
+	if !ok || len(and) != 2 || and[0]["port"] != 25565 {
+		t.Fatalf("expected the custom filter to be kept, got %v", filter)
+	}
+}
+
+func TestStreamCollectionUnreachable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	// Nothing listens on port 1, the ping fails instead of waiting for the cursor
+	_, err := StreamCollection(ctx, "mongodb://127.0.0.1:1", "http", bson.M{}, []uint16{80}, targets.Exclusions{}, nil)
+	if err == nil {
+		t.Fatal("expected an unreachable database to fail")
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the context to bound the connection, took %s", elapsed)
+	}
+}
@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Where the documents of a scanner are saved, *mongo.Collection is the one of the database
+type Store interface {
+	ReplaceOne(ctx context.Context, filter any, replacement any, opts ...*options.ReplaceOptions) (*mongo.UpdateResult, error)
+	UpdateOne(ctx context.Context, filter any, update any, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+}
+
+// Store adding fields to every document replaced through it, they are saved in the same write as the document.
+// Updates are passed through unchanged.
+func WithFields(store Store, fields bson.M) Store {
+	return fieldsStore{Store: store, fields: fields}
+}
+
+type fieldsStore struct {
+	Store
+	fields bson.M
+}
+
+func (s fieldsStore) ReplaceOne(ctx context.Context, filter any, replacement any, opts ...*options.ReplaceOptions) (*mongo.UpdateResult, error) {
+	var document bson.M
+
+	// The documents of the scanners are already maps, only copied
+	if m, ok := replacement.(bson.M); ok {
+		document = make(bson.M, len(m)+len(s.fields))
+		for field, value := range m {
+			document[field] = value
+		}
+	} else {
+		var err error
+		document, err = toDocument(replacement)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for field, value := range s.fields {
+		document[field] = value
+	}
+
+	return s.Store.ReplaceOne(ctx, filter, document, opts...)
+}
+
+// Documents kept in memory by _id, for tests. Only what the scanners and the engine use is supported:
+// filters matching fields by value or with $exists, and updates with $set.
+type MemoryStore struct {
+	mu        sync.Mutex
+	documents map[any]bson.M
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{documents: make(map[any]bson.M)}
+}
+
+func (s *MemoryStore) ReplaceOne(ctx context.Context, filter any, replacement any, opts ...*options.ReplaceOptions) (*mongo.UpdateResult, error) {
+	// Encoded like the database would, the document read back has the types of BSON
+	document, err := toDocument(replacement)
+	if err != nil {
+		return nil, err
+	}
+
+	upsert := false
+	for _, opt := range opts {
+		if opt != nil && opt.Upsert != nil {
+			upsert = *opt.Upsert
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, matched, err := s.find(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if !matched {
+		if !upsert {
+			return &mongo.UpdateResult{}, nil
+		}
+
+		id = document["_id"]
+		if id == nil {
+			return nil, fmt.Errorf("document without an _id")
+		}
+
+		s.documents[id] = document
+		return &mongo.UpdateResult{UpsertedCount: 1, UpsertedID: id}, nil
+	}
+
+	document["_id"] = id
+	s.documents[id] = document
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+func (s *MemoryStore) UpdateOne(ctx context.Context, filter any, update any, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	changes, err := toDocument(update)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, matched, err := s.find(filter)
+	if err != nil || !matched {
+		return &mongo.UpdateResult{}, err
+	}
+
+	for operator, fields := range changes {
+		set, ok := fields.(bson.M)
+		if operator != "$set" || !ok {
+			return nil, fmt.Errorf("unsupported update operator '%s'", operator)
+		}
+
+		for field, value := range set {
+			s.documents[id][field] = value
+		}
+	}
+
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+// Copy of the document with the _id, nil if there is none
+func (s *MemoryStore) Get(id any) bson.M {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	document, ok := s.documents[id]
+	if !ok {
+		return nil
+	}
+
+	copied := make(bson.M, len(document))
+	for field, value := range document {
+		copied[field] = value
+	}
+
+	return copied
+}
+
+// Amount of documents
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.documents)
+}
+
+// Returns the _id of the first document matching filter, called with mu held
+func (s *MemoryStore) find(filter any) (any, bool, error) {
+	conditions, err := toDocument(filter)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for id, document := range s.documents {
+		matched := true
+
+		for field, condition := range conditions {
+			value, exists := document[field]
+
+			if operators, ok := condition.(bson.M); ok {
+				if len(operators) != 1 || operators["$exists"] == nil {
+					return nil, false, fmt.Errorf("unsupported condition on '%s'", field)
+				}
+
+				matched = matched && operators["$exists"] == exists
+				continue
+			}
+
+			matched = matched && exists && reflect.DeepEqual(value, condition)
+		}
+
+		if matched {
+			return id, true, nil
+		}
+	}
+
+	return nil, false, nil
+}
+
+// Encodes and decodes v, any document becomes a bson.M with the types read from the database
+func toDocument(v any) (bson.M, error) {
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var document bson.M
+	err = bson.Unmarshal(raw, &document)
+	return document, err
+}
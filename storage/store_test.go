@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	target := netip.MustParseAddrPort("192.0.2.1:80")
+	ctx := context.Background()
+
+	save := func(data string) {
+		t.Helper()
+
+		document := bson.M{"_id": "192.0.2.1:80", "port": target.Port(), "data": data}
+		_, err := store.ReplaceOne(ctx, bson.M{"_id": "192.0.2.1:80"}, document, options.Replace().SetUpsert(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	save("first")
+
+	err := MarkOffline(ctx, target, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	since := store.Get("192.0.2.1:80")["offline_since"]
+	if since == nil {
+		t.Fatal("expected the host to be marked as offline")
+	}
+
+	// Only the first time is kept
+	err = MarkOffline(ctx, target, store)
+	if err != nil || store.Get("192.0.2.1:80")["offline_since"] != since {
+		t.Fatalf("expected the first mark to be kept (%v)", err)
+	}
+
+	// Saving it again removes the mark, the egress is written with the document
+	document := bson.M{"_id": "192.0.2.1:80", "port": target.Port(), "data": "second"}
+	_, err = WithFields(store, bson.M{"egress": "socks5://192.0.2.9:1080"}).ReplaceOne(ctx, bson.M{"_id": "192.0.2.1:80"}, document, options.Replace().SetUpsert(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if document["egress"] != nil {
+		t.Fatal("expected the document of the scanner to be left unchanged")
+	}
+
+	document = store.Get("192.0.2.1:80")
+	if document["data"] != "second" || document["offline_since"] != nil || document["egress"] != "socks5://192.0.2.9:1080" || document["port"] != int32(80) {
+		t.Fatalf("unexpected document %v", document)
+	}
+
+	if store.Len() != 1 {
+		t.Fatalf("expected a single document, got %d", store.Len())
+	}
+}
@@ -0,0 +1,147 @@
+package syn
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"net"
+	"net/netip"
+	"sync"
+)
+
+const (
+	// Length of the TCP header sent, without options
+	HEADER_LENGTH = 20
+	// Window advertised in the SYNs
+	WINDOW = 1024
+
+	FLAG_SYN = 0x02
+	FLAG_RST = 0x04
+	FLAG_ACK = 0x10
+)
+
+// Sends SYNs from a raw socket and reports the targets that answered with a SYN-ACK.
+//
+// Nothing is kept per target, the sequence number of every SYN is a cookie keyed by a random secret and
+// the addresses and ports of the probe, an answer is only valid if it acknowledges the cookie. The kernel
+// doesn't know about the probes and answers the SYN-ACKs with a RST, no connection is left open.
+type Prober struct {
+	conn   *net.IPConn
+	source netip.Addr
+	// Port every SYN is sent from
+	port uint16
+	key  maphash.Seed
+
+	answers chan netip.AddrPort
+	// Closed by Close, stops the read loop
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Targets that answered a SYN, closed by Close
+func (p *Prober) Answers() <-chan netip.AddrPort {
+	return p.answers
+}
+
+// Address the SYNs are sent from
+func (p *Prober) Source() netip.Addr {
+	return p.source
+}
+
+// Stops receiving answers and closes the socket
+func (p *Prober) Close() error {
+	err := error(nil)
+
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		err = p.conn.Close()
+	})
+
+	return err
+}
+
+// Sequence number of the SYN sent from source:port to target
+func (p *Prober) cookie(target netip.AddrPort) uint32 {
+	var buf [12]byte
+
+	source := p.source.As4()
+	destination := target.Addr().As4()
+
+	copy(buf[0:4], source[:])
+	copy(buf[4:8], destination[:])
+	binary.BigEndian.PutUint16(buf[8:10], p.port)
+	binary.BigEndian.PutUint16(buf[10:12], target.Port())
+
+	return uint32(maphash.Bytes(p.key, buf[:]))
+}
+
+// Builds the TCP header of a SYN to target, the IP header is added by the kernel
+func (p *Prober) segment(target netip.AddrPort) []byte {
+	segment := make([]byte, HEADER_LENGTH)
+
+	binary.BigEndian.PutUint16(segment[0:2], p.port)
+	binary.BigEndian.PutUint16(segment[2:4], target.Port())
+	binary.BigEndian.PutUint32(segment[4:8], p.cookie(target))
+	// Data offset, in 32 bit words
+	segment[12] = HEADER_LENGTH / 4 << 4
+	segment[13] = FLAG_SYN
+	binary.BigEndian.PutUint16(segment[14:16], WINDOW)
+
+	binary.BigEndian.PutUint16(segment[16:18], checksum(p.source, target.Addr(), segment))
+	return segment
+}
+
+// Returns the target that sent the segment if it is a SYN-ACK answering one of the SYNs
+func (p *Prober) parse(from netip.Addr, segment []byte) (netip.AddrPort, bool) {
+	if len(segment) < HEADER_LENGTH || !from.Is4() {
+		return netip.AddrPort{}, false
+	}
+
+	if binary.BigEndian.Uint16(segment[2:4]) != p.port {
+		return netip.AddrPort{}, false
+	}
+
+	// Closed ports answer with a RST
+	if segment[13]&(FLAG_SYN|FLAG_ACK|FLAG_RST) != FLAG_SYN|FLAG_ACK {
+		return netip.AddrPort{}, false
+	}
+
+	target := netip.AddrPortFrom(from, binary.BigEndian.Uint16(segment[0:2]))
+
+	// Anything else wasn't sent by this prober, or was forged
+	if binary.BigEndian.Uint32(segment[8:12]) != p.cookie(target)+1 {
+		return netip.AddrPort{}, false
+	}
+
+	return target, true
+}
+
+// Checksum of a TCP segment, including the pseudo header of IPv4
+func checksum(source netip.Addr, destination netip.Addr, segment []byte) uint16 {
+	src := source.As4()
+	dst := destination.As4()
+
+	sum := uint32(0)
+	sum += uint32(binary.BigEndian.Uint16(src[0:2])) + uint32(binary.BigEndian.Uint16(src[2:4]))
+	sum += uint32(binary.BigEndian.Uint16(dst[0:2])) + uint32(binary.BigEndian.Uint16(dst[2:4]))
+	// Protocol and length of the segment
+	sum += 6 + uint32(len(segment))
+
+	for i := 0; i+1 < len(segment); i += 2 {
+		// The checksum itself counts as zero
+		if i == 16 {
+			continue
+		}
+
+		sum += uint32(binary.BigEndian.Uint16(segment[i : i+2]))
+	}
+
+	if len(segment)%2 == 1 {
+		sum += uint32(segment[len(segment)-1]) << 8
+	}
+
+	for sum > 0xFFFF {
+		sum = sum&0xFFFF + sum>>16
+	}
+
+	return ^uint16(sum)
+}
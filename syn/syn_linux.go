@@ -0,0 +1,107 @@
+//go:build linux
+
+package syn
+
+import (
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+)
+
+// Size of the receive buffer of the socket, answers arriving while it is full are lost
+const READ_BUFFER_SIZE = 8 * 1024 * 1024
+
+// Opens the raw socket the SYNs are sent from and the answers received on, which needs CAP_NET_RAW.
+// Without a source, the address of the default route is used.
+func New(source netip.Addr) (*Prober, error) {
+	if !source.IsValid() {
+		var err error
+		source, err = defaultSource()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !source.Is4() {
+		return nil, fmt.Errorf("source address '%s' is not an IPv4 address", source)
+	}
+
+	conn, err := net.ListenIP("ip4:tcp", &net.IPAddr{IP: source.AsSlice()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open raw socket, CAP_NET_RAW is needed: %s", err)
+	}
+
+	// Best effort, the default buffer only holds a few answers
+	conn.SetReadBuffer(READ_BUFFER_SIZE)
+
+	p := &Prober{
+		conn:    conn,
+		source:  source,
+		port:    uint16(32768 + rand.IntN(28232)),
+		key:     maphash.MakeSeed(),
+		answers: make(chan netip.AddrPort, 4096),
+		closed:  make(chan struct{}),
+	}
+
+	go p.read()
+	return p, nil
+}
+
+// Sends a SYN to the target, only IPv4 targets can be probed
+func (p *Prober) Probe(target netip.AddrPort) error {
+	if !target.Addr().Is4() {
+		return errors.ErrUnsupported
+	}
+
+	_, err := p.conn.WriteToIP(p.segment(target), &net.IPAddr{IP: target.Addr().AsSlice()})
+	return err
+}
+
+// Receives every TCP segment sent to the source and passes the valid answers to Answers, until Close
+func (p *Prober) read() {
+	defer close(p.answers)
+
+	// The IPv4 header is removed by ReadFromIP
+	buf := make([]byte, 1500)
+
+	for {
+		n, from, err := p.conn.ReadFromIP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			continue
+		}
+
+		addr, ok := netip.AddrFromSlice(from.IP)
+		if !ok {
+			continue
+		}
+
+		target, ok := p.parse(addr.Unmap(), buf[:n])
+		if !ok {
+			continue
+		}
+
+		select {
+		case p.answers <- target:
+		case <-p.closed:
+			return
+		}
+	}
+}
+
+// Address the kernel would use to reach the internet, connecting a UDP socket sends nothing
+func defaultSource() (netip.Addr, error) {
+	conn, err := net.Dial("udp4", "192.0.2.1:9")
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("failed to find the source address, set it with -syn-source: %s", err)
+	}
+
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).AddrPort().Addr().Unmap(), nil
+}
@@ -0,0 +1,65 @@
+//go:build linux
+
+package syn
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// Needs CAP_NET_RAW, run it as root or inside a network namespace: unshare -rn sh -c 'ip link set lo up && go test ./syn'
+func TestProberLoopback(t *testing.T) {
+	p, err := New(netip.MustParseAddr("127.0.0.1"))
+	if err != nil {
+		t.Skipf("raw sockets not available: %s", err)
+	}
+
+	defer p.Close()
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+	open := netip.MustParseAddrPort(listener.Addr().String())
+
+	closedListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closed := netip.MustParseAddrPort(closedListener.Addr().String())
+	closedListener.Close()
+
+	for _, target := range []netip.AddrPort{closed, open} {
+		err := p.Probe(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case target := <-p.Answers():
+		if target != open {
+			t.Fatalf("expected only %s to answer, got %s", open, target)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected the listener to answer the SYN")
+	}
+
+	// The RST of the closed port is not an answer
+	select {
+	case target := <-p.Answers():
+		t.Fatalf("unexpected answer from %s", target)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	p.Close()
+
+	// Closed once the read loop stopped
+	for range p.Answers() {
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package syn
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// Raw TCP sockets only receive the answers on Linux
+func New(source netip.Addr) (*Prober, error) {
+	return nil, errors.New("the SYN prescan is only supported on Linux")
+}
+
+func (p *Prober) Probe(target netip.AddrPort) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,71 @@
+package syn
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"net/netip"
+	"testing"
+)
+
+// Answer of target to the SYN, with the given flags and acknowledgment number
+func answer(target netip.AddrPort, port uint16, flags byte, ack uint32) []byte {
+	segment := make([]byte, HEADER_LENGTH)
+	binary.BigEndian.PutUint16(segment[0:2], target.Port())
+	binary.BigEndian.PutUint16(segment[2:4], port)
+	binary.BigEndian.PutUint32(segment[8:12], ack)
+	segment[13] = flags
+	return segment
+}
+
+func TestCookie(t *testing.T) {
+	p := &Prober{source: netip.MustParseAddr("192.0.2.1"), port: 40000, key: maphash.MakeSeed()}
+	target := netip.MustParseAddrPort("198.51.100.7:80")
+
+	syn := p.segment(target)
+	seq := binary.BigEndian.Uint32(syn[4:8])
+
+	if syn[13] != FLAG_SYN || binary.BigEndian.Uint16(syn[2:4]) != 80 {
+		t.Fatalf("unexpected SYN %x", syn)
+	}
+
+	if checksum(p.source, target.Addr(), syn) != binary.BigEndian.Uint16(syn[16:18]) {
+		t.Fatal("expected the checksum to match the segment")
+	}
+
+	found, ok := p.parse(target.Addr(), answer(target, p.port, FLAG_SYN|FLAG_ACK, seq+1))
+	if !ok || found != target {
+		t.Fatalf("expected the SYN-ACK to be valid, got %s %t", found, ok)
+	}
+
+	invalid := map[string][]byte{
+		"wrong ack":  answer(target, p.port, FLAG_SYN|FLAG_ACK, seq),
+		"rst":        answer(target, p.port, FLAG_RST|FLAG_ACK, seq+1),
+		"wrong port": answer(target, p.port+1, FLAG_SYN|FLAG_ACK, seq+1),
+		"truncated":  answer(target, p.port, FLAG_SYN|FLAG_ACK, seq+1)[:12],
+	}
+
+	for name, segment := range invalid {
+		if _, ok := p.parse(target.Addr(), segment); ok {
+			t.Fatalf("expected the %s answer to be rejected", name)
+		}
+	}
+
+	// The cookie depends on the secret, another prober can't validate it
+	other := &Prober{source: p.source, port: p.port, key: maphash.MakeSeed()}
+	if _, ok := other.parse(target.Addr(), answer(target, p.port, FLAG_SYN|FLAG_ACK, seq+1)); ok {
+		t.Fatal("expected the answer to be rejected with another secret")
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	// SYN from 10.0.0.1:12345 to 10.0.0.2:80, with a checksum of 0x6756
+	segment := []byte{
+		0x30, 0x39, 0x00, 0x50, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+		0x50, 0x02, 0x04, 0x00, 0x67, 0x56, 0x00, 0x00,
+	}
+
+	sum := checksum(netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2"), segment)
+	if sum != 0x6756 {
+		t.Fatalf("expected checksum 0x6756, got %#x", sum)
+	}
+}
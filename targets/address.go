@@ -0,0 +1,227 @@
+package targets
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Converts an IP and port to a string
+func ParseAddress(ip uint32, port uint16) string {
+	var address [21]byte
+	i := 0
+
+	// Helper function to write a 3-digit segment into the buffer
+	appendSegment := func(segment byte) {
+		if segment >= 100 {
+			address[i] = '0' + segment/100
+			i++
+			segment %= 100
+		}
+
+		if segment >= 10 {
+			address[i] = '0' + segment/10
+			i++
+			segment %= 10
+		}
+
+		address[i] = '0' + segment
+		i++
+	}
+
+	appendSegment(byte(ip >> 24))
+	address[i] = '.'
+	i++
+
+	appendSegment(byte(ip >> 16))
+	address[i] = '.'
+	i++
+
+	appendSegment(byte(ip >> 8))
+	address[i] = '.'
+	i++
+
+	appendSegment(byte(ip))
+
+	address[i] = ':'
+	i++
+
+	start := i
+	if port >= 10000 {
+		address[i] = '0' + byte(port/10000)
+		i++
+		port %= 10000
+	}
+
+	if port >= 1000 || i > start {
+		address[i] = '0' + byte(port/1000)
+		i++
+		port %= 1000
+	}
+
+	if port >= 100 || i > start {
+		address[i] = '0' + byte(port/100)
+		i++
+		port %= 100
+	}
+
+	if port >= 10 || i > start {
+		address[i] = '0' + byte(port/10)
+		i++
+		port %= 10
+	}
+
+	address[i] = '0' + byte(port)
+	i++
+
+	return string(address[:i])
+}
+
+// Converts an IP (x.x.x.x) string to an uint32
+func ParseIP(ip string) (uint32, error) {
+	if ip == "" {
+		return 1 << 24, nil
+	}
+
+	octets := strings.Split(ip, ".")
+	if len(octets) != 4 {
+		return 0, fmt.Errorf("invalid IP address '%s'", ip)
+	}
+
+	segA, err := strconv.Atoi(octets[0])
+	if err != nil || segA < 0 || segA > 255 {
+		return 0, fmt.Errorf("invalid segment '%s' in IP '%s'", octets[0], ip)
+	}
+
+	segB, err := strconv.Atoi(octets[1])
+	if err != nil || segB < 0 || segB > 255 {
+		return 0, fmt.Errorf("invalid segment '%s' in IP '%s'", octets[1], ip)
+	}
+
+	segC, err := strconv.Atoi(octets[2])
+	if err != nil || segC < 0 || segC > 255 {
+		return 0, fmt.Errorf("invalid segment '%s' in IP '%s'", octets[2], ip)
+	}
+
+	segD, err := strconv.Atoi(octets[3])
+	if err != nil || segD < 0 || segD > 255 {
+		return 0, fmt.Errorf("invalid segment '%s' in IP '%s'", octets[3], ip)
+	}
+
+	parsed := (uint32(segA) << 24) | (uint32(segB) << 16) | (uint32(segC) << 8) | uint32(segD)
+	return parsed, nil
+}
+
+// Converts an IPv4 address to a netip.Addr
+func AddrFrom4(ip uint32) netip.Addr {
+	return netip.AddrFrom4([4]byte{byte(ip >> 24), byte(ip >> 16), byte(ip >> 8), byte(ip)})
+}
+
+// Converts a netip.Addr to an IPv4 address, addr must be an IPv4 address
+func addrTo4(addr netip.Addr) uint32 {
+	b := addr.As4()
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+// Converts a target to a string, IPv6 addresses are enclosed in brackets
+func FormatTarget(target netip.AddrPort) string {
+	if target.Addr().Is4() {
+		return ParseAddress(addrTo4(target.Addr()), target.Port())
+	}
+
+	return target.String()
+}
+
+// Parses a comma separated list of ports and port ranges (80,8000-8100), duplicates are removed
+func ParsePorts(spec string) ([]uint16, error) {
+	var ports []uint16
+	seen := make(map[uint16]bool)
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+
+		first, last, isRange := strings.Cut(part, "-")
+		if !isRange {
+			last = first
+		}
+
+		start, err := strconv.ParseUint(first, 10, 16)
+		if err != nil || start == 0 {
+			return nil, fmt.Errorf("invalid port '%s'", first)
+		}
+
+		end, err := strconv.ParseUint(last, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port '%s'", last)
+		}
+
+		if end < start {
+			return nil, fmt.Errorf("invalid port range '%s', end is lower than start", part)
+		}
+
+		for port := start; port <= end; port++ {
+			if seen[uint16(port)] {
+				continue
+			}
+
+			seen[uint16(port)] = true
+			ports = append(ports, uint16(port))
+		}
+	}
+
+	return ports, nil
+}
+
+// Addresses a prefix parsed by ParseAddrs can have
+const MAX_PARSED_ADDRS = 1 << 16
+
+// Parses a comma separated list of addresses and prefixes (192.0.2.1,198.51.100.0/29), duplicates are removed.
+// The network and broadcast addresses of IPv4 prefixes are skipped.
+func ParseAddrs(spec string) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	seen := make(map[netip.Addr]bool)
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+
+		var expanded []netip.Addr
+
+		if !strings.Contains(part, "/") {
+			addr, err := netip.ParseAddr(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address '%s'", part)
+			}
+
+			expanded = append(expanded, addr.Unmap())
+		} else {
+			prefix, err := netip.ParsePrefix(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid prefix '%s'", part)
+			}
+
+			if prefix.Addr().BitLen()-prefix.Bits() > 16 {
+				return nil, fmt.Errorf("prefix '%s' has more than %d addresses", part, MAX_PARSED_ADDRS)
+			}
+
+			prefix = prefix.Masked()
+			for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
+				expanded = append(expanded, addr)
+			}
+
+			// Not usable as host addresses
+			if prefix.Addr().Is4() && len(expanded) > 2 {
+				expanded = expanded[1 : len(expanded)-1]
+			}
+		}
+
+		for _, addr := range expanded {
+			if !seen[addr] {
+				seen[addr] = true
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+
+	return addrs, nil
+}
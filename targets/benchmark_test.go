@@ -1,8 +1,6 @@
-package main
+package targets
 
-import (
-	"testing"
-)
+import "testing"
 
 func BenchmarkIPAndPort(b *testing.B) {
 	b.ReportAllocs()
@@ -19,7 +17,7 @@ func BenchmarkIPAndPort(b *testing.B) {
 			}
 			j--
 
-			address := parseAddress(ip, port)
+			address := ParseAddress(ip, port)
 			if address == "" {
 				b.Fail()
 			}
@@ -0,0 +1,101 @@
+//go:build ignore
+
+// Writes reserved_table.go from the vendored copy of the IANA IPv4 special-purpose address registry,
+// run with '-download' to refresh the copy first
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/Kyagara/hagelslag/targets/internal/iana"
+)
+
+const (
+	REGISTRY_PATH = "testdata/iana-ipv4-special-registry-1.csv"
+	TABLE_PATH    = "reserved_table.go"
+)
+
+func main() {
+	download := flag.Bool("download", false, "Download the registry from IANA before generating")
+	flag.Parse()
+
+	err := generate(*download)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func generate(download bool) error {
+	if download {
+		response, err := http.Get(iana.SPECIAL_REGISTRY_URL)
+		if err != nil {
+			return fmt.Errorf("failed to download registry: %s", err)
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to download registry: %s", response.Status)
+		}
+
+		registry, err := io.ReadAll(response.Body)
+		if err != nil {
+			return fmt.Errorf("failed to download registry: %s", err)
+		}
+
+		err = os.WriteFile(REGISTRY_PATH, registry, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to save registry: %s", err)
+		}
+	}
+
+	file, err := os.Open(REGISTRY_PATH)
+	if err != nil {
+		return fmt.Errorf("failed to open registry: %s", err)
+	}
+	defer file.Close()
+
+	entries, err := iana.ParseSpecialRegistry(file)
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	b.WriteString("// Code generated by gen_reserved.go from " + REGISTRY_PATH + "; DO NOT EDIT.\n\n")
+	b.WriteString("package targets\n\n")
+
+	table(&b, entries, "SPECIAL_PURPOSE_REGISTRY", "False", "Blocks of the IANA IPv4 special-purpose address registry that are not globally reachable")
+	b.WriteString("\n")
+	table(&b, entries, "GLOBALLY_REACHABLE", "True", "Blocks of the IANA IPv4 special-purpose address registry that are globally reachable, they stay\n// scannable even when nested inside a block of SPECIAL_PURPOSE_REGISTRY")
+
+	source, err := format.Source(b.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to format table: %s", err)
+	}
+
+	err = os.WriteFile(TABLE_PATH, source, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write table: %s", err)
+	}
+
+	return nil
+}
+
+// Writes the entries whose Globally Reachable column is reachable
+func table(b *bytes.Buffer, entries []iana.Entry, name string, reachable string, doc string) {
+	fmt.Fprintf(b, "// %s\nvar %s = []SpecialPurpose{\n", doc, name)
+
+	for _, entry := range entries {
+		if entry.GloballyReachable == reachable {
+			fmt.Fprintf(b, "{Prefix: %q, Name: %q, RFC: %q},\n", entry.Prefix.String(), entry.Name, entry.RFC)
+		}
+	}
+
+	b.WriteString("}\n")
+}
@@ -0,0 +1,78 @@
+// Parses the CSV of the IANA IPv4 special-purpose address registry
+package iana
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/netip"
+	"regexp"
+	"strings"
+)
+
+// Published by IANA, the vendored copy is in targets/testdata
+const SPECIAL_REGISTRY_URL = "https://www.iana.org/assignments/iana-ipv4-special-registry/iana-ipv4-special-registry-1.csv"
+
+var (
+	// Footnotes like '[2]'
+	FOOTNOTE = regexp.MustCompile(`\s*\[\d+\]`)
+	// References like '[RFC6890]'
+	REFERENCE = regexp.MustCompile(`\[RFC(\d+)\]`)
+)
+
+// Block of the registry, a row listing several blocks is split into an entry for each
+type Entry struct {
+	Prefix netip.Prefix
+	Name   string
+	// References with 'RFC 6890, Section 2.1' spacing
+	RFC string
+	// 'True', 'False' or empty, terminated blocks leave it empty
+	GloballyReachable string
+}
+
+// Reads every block of the registry in the order of the file
+func ParseSpecialRegistry(r io.Reader) ([]Entry, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registry: %s", err)
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("empty registry")
+	}
+
+	columns := map[string]int{}
+	for i, name := range rows[0] {
+		columns[strings.TrimSpace(name)] = i
+	}
+
+	for _, name := range []string{"Address Block", "Name", "RFC", "Globally Reachable"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("registry without a '%s' column", name)
+		}
+	}
+
+	var entries []Entry
+
+	for _, row := range rows[1:] {
+		field := func(name string) string {
+			return strings.TrimSpace(FOOTNOTE.ReplaceAllString(row[columns[name]], ""))
+		}
+
+		name := strings.Trim(field("Name"), `"`)
+		// '[RFC8880][RFC7050]' and '[RFC8190] [RFC919]' become 'RFC 8880, RFC 7050' and 'RFC 8190, RFC 919'
+		rfc := strings.NewReplacer("][", "], [", "] [", "], [").Replace(field("RFC"))
+		rfc = REFERENCE.ReplaceAllString(rfc, "RFC $1")
+
+		for _, block := range strings.Split(field("Address Block"), ",") {
+			prefix, err := netip.ParsePrefix(strings.TrimSpace(block))
+			if err != nil {
+				return nil, fmt.Errorf("invalid address block '%s': %s", block, err)
+			}
+
+			entries = append(entries, Entry{Prefix: prefix, Name: name, RFC: rfc, GloballyReachable: field("Globally Reachable")})
+		}
+	}
+
+	return entries, nil
+}
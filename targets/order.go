@@ -0,0 +1,296 @@
+package targets
+
+import (
+	"fmt"
+	"math/big"
+	"math/bits"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+// Order in which the indices of the targets are visited
+type Order interface {
+	// Returns the next index, false when all indices were visited
+	Next() (uint64, bool)
+	// Amount of indices already visited and the total amount of indices
+	Progress() (uint64, uint64)
+	// Amount of steps taken in the walk
+	Position() uint64
+	// Moves the walk to position, as if position steps were taken
+	SetPosition(position uint64)
+}
+
+// Partition of an order, shard Index out of Count takes every Count-th step of the walk starting at step Index
+type Shard struct {
+	Index uint64
+	Count uint64
+}
+
+// The whole walk
+var NO_SHARD = Shard{Index: 0, Count: 1}
+
+// Parses a shard in the 'i/n' format, i starts at 0
+func ParseShard(shard string) (Shard, error) {
+	index, count, found := strings.Cut(shard, "/")
+	if !found {
+		return Shard{}, fmt.Errorf("invalid shard '%s', expected 'i/n'", shard)
+	}
+
+	i, err := strconv.ParseUint(index, 10, 64)
+	if err != nil {
+		return Shard{}, fmt.Errorf("invalid shard index '%s'", index)
+	}
+
+	n, err := strconv.ParseUint(count, 10, 64)
+	if err != nil || n == 0 {
+		return Shard{}, fmt.Errorf("invalid shard count '%s'", count)
+	}
+
+	if i >= n {
+		return Shard{}, fmt.Errorf("shard index %d must be lower than the shard count %d", i, n)
+	}
+
+	return Shard{Index: i, Count: n}, nil
+}
+
+// Splits the shard into count sub-shards and returns the one at index.
+// The sub-shards of a shard are disjoint and together cover the whole shard.
+func (s Shard) Split(index uint64, count uint64) Shard {
+	return Shard{Index: s.Index + index*s.Count, Count: s.Count * count}
+}
+
+func (s Shard) String() string {
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
+
+// Creates the order with the name, seed is only used by random orders
+func NewOrder(name string, size uint64, seed uint64, shard Shard) (Order, error) {
+	switch name {
+	case "sequential":
+		return NewSequential(size, shard), nil
+	case "random":
+		return NewCycle(size, seed, shard), nil
+	default:
+		return nil, fmt.Errorf("unknown order '%s'", name)
+	}
+}
+
+// Visits the indices in [0, size) in increasing order
+type Sequential struct {
+	shard Shard
+	// Amount of steps taken
+	position uint64
+	steps    uint64
+}
+
+func NewSequential(size uint64, shard Shard) *Sequential {
+	return &Sequential{
+		shard: shard,
+		steps: stepsInShard(size, shard),
+	}
+}
+
+func (s *Sequential) Next() (uint64, bool) {
+	if s.position >= s.steps {
+		return 0, false
+	}
+
+	index := s.shard.Index + s.position*s.shard.Count
+	s.position++
+	return index, true
+}
+
+func (s *Sequential) Progress() (uint64, uint64) {
+	return s.position, s.steps
+}
+
+func (s *Sequential) Position() uint64 {
+	return s.position
+}
+
+func (s *Sequential) SetPosition(position uint64) {
+	s.position = min(position, s.steps)
+}
+
+// Moves forward to the first index of the shard equal or greater than index, used to jump past excluded blocks
+func (s *Sequential) Seek(index uint64) {
+	if index <= s.shard.Index {
+		return
+	}
+
+	position := (index - s.shard.Index + s.shard.Count - 1) / s.shard.Count
+	s.position = max(s.position, min(position, s.steps))
+}
+
+// Visits every index in [0, size) exactly once in a pseudo random order using O(1) memory.
+//
+// Like ZMap, it walks the multiplicative group of integers modulo a prime larger than size,
+// elements of the group are mapped to indices and the ones outside of [0, size) are skipped.
+// The generator of the group and the first element are picked from the seed, the same seed
+// always produces the same order. Shards walk the same cycle, each taking a different set of steps.
+type Cycle struct {
+	prime     uint64
+	generator uint64
+	first     uint64
+	current   uint64
+	size      uint64
+	shard     Shard
+	// Generator raised to the shard count, moves the cycle to the next step of the shard
+	multiplier uint64
+
+	// Amount of group elements already walked and the total for this shard, the whole cycle has prime - 1 elements
+	position uint64
+	steps    uint64
+}
+
+func NewCycle(size uint64, seed uint64, shard Shard) *Cycle {
+	prime := nextPrime(size + 1)
+	rng := rand.New(rand.NewPCG(seed, ^seed))
+
+	// The group of 2 only has 1
+	generator := uint64(1)
+	if prime > 2 {
+		factors := primeFactors(prime - 1)
+		for {
+			generator = 2 + rng.Uint64N(prime-2)
+			if isPrimitiveRoot(generator, prime, factors) {
+				break
+			}
+		}
+	}
+
+	c := &Cycle{
+		prime:      prime,
+		generator:  generator,
+		first:      1 + rng.Uint64N(prime-1),
+		size:       size,
+		shard:      shard,
+		multiplier: powMod(generator, shard.Count, prime),
+		steps:      stepsInShard(prime-1, shard),
+	}
+
+	c.SetPosition(0)
+	return c
+}
+
+func (c *Cycle) Next() (uint64, bool) {
+	for c.position < c.steps {
+		element := c.current
+		c.current = mulMod(c.current, c.multiplier, c.prime)
+		c.position++
+
+		// Elements start at 1
+		if element-1 < c.size {
+			return element - 1, true
+		}
+	}
+
+	return 0, false
+}
+
+// Estimated from the amount of steps taken, since the elements outside of [0, size) are spread through the cycle.
+// The total is exact for a whole cycle.
+func (c *Cycle) Progress() (uint64, uint64) {
+	return c.scale(c.position), c.scale(c.steps)
+}
+
+func (c *Cycle) Position() uint64 {
+	return c.position
+}
+
+func (c *Cycle) SetPosition(position uint64) {
+	c.position = min(position, c.steps)
+
+	// The step of the shard in the whole cycle
+	step := c.shard.Index + c.position*c.shard.Count
+	c.current = mulMod(c.first, powMod(c.generator, step, c.prime), c.prime)
+}
+
+// Returns steps * size / (prime - 1), the amount of indices expected in that many steps
+func (c *Cycle) scale(steps uint64) uint64 {
+	// Never larger than size, so it can't overflow
+	hi, lo := bits.Mul64(c.size, steps)
+	scaled, _ := bits.Div64(hi, lo, c.prime-1)
+	return scaled
+}
+
+// Amount of steps of a walk of length size that belong to the shard
+func stepsInShard(size uint64, shard Shard) uint64 {
+	if shard.Index >= size {
+		return 0
+	}
+
+	return (size - shard.Index + shard.Count - 1) / shard.Count
+}
+
+// Returns (a * b) % m without overflowing
+func mulMod(a uint64, b uint64, m uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	return bits.Rem64(hi, lo, m)
+}
+
+// Returns (base ^ exp) % m
+func powMod(base uint64, exp uint64, m uint64) uint64 {
+	result := uint64(1) % m
+	base %= m
+
+	for exp > 0 {
+		if exp&1 == 1 {
+			result = mulMod(result, base, m)
+		}
+
+		base = mulMod(base, base, m)
+		exp >>= 1
+	}
+
+	return result
+}
+
+// Returns the smallest prime equal or greater than n
+func nextPrime(n uint64) uint64 {
+	n = max(n, 2)
+
+	for {
+		// Deterministic for numbers lower than 2^64
+		if new(big.Int).SetUint64(n).ProbablyPrime(0) {
+			return n
+		}
+
+		n++
+	}
+}
+
+// Returns the distinct prime factors of n
+func primeFactors(n uint64) []uint64 {
+	var factors []uint64
+
+	for p := uint64(2); p*p <= n; p++ {
+		if n%p != 0 {
+			continue
+		}
+
+		factors = append(factors, p)
+		for n%p == 0 {
+			n /= p
+		}
+	}
+
+	if n > 1 {
+		factors = append(factors, n)
+	}
+
+	return factors
+}
+
+// Reports whether g generates the whole multiplicative group modulo prime,
+// factors are the distinct prime factors of prime - 1
+func isPrimitiveRoot(g uint64, prime uint64, factors []uint64) bool {
+	for _, q := range factors {
+		if powMod(g, (prime-1)/q, prime) == 1 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,163 @@
+package targets
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCycleVisitsEveryIndexOnce(t *testing.T) {
+	for _, size := range []uint64{0, 1, 2, 3, 10, 256, 1000, 65536, 100003} {
+		for _, seed := range []uint64{1, 42, 1 << 63} {
+			cycle := NewCycle(size, seed, NO_SHARD)
+			seen := make([]bool, size)
+			visited := uint64(0)
+
+			for {
+				index, ok := cycle.Next()
+				if !ok {
+					break
+				}
+
+				if index >= size {
+					t.Fatalf("size %d seed %d: index %d out of range", size, seed, index)
+				}
+
+				if seen[index] {
+					t.Fatalf("size %d seed %d: index %d visited twice", size, seed, index)
+				}
+
+				seen[index] = true
+				visited++
+			}
+
+			if visited != size {
+				t.Fatalf("size %d seed %d: visited %d indices", size, seed, visited)
+			}
+
+			position, total := cycle.Progress()
+			if position != total {
+				t.Fatalf("size %d seed %d: progress %d/%d", size, seed, position, total)
+			}
+		}
+	}
+}
+
+func TestCycleSeedReproducible(t *testing.T) {
+	walk := func(seed uint64) []uint64 {
+		var indices []uint64
+
+		cycle := NewCycle(5000, seed, NO_SHARD)
+		for {
+			index, ok := cycle.Next()
+			if !ok {
+				return indices
+			}
+
+			indices = append(indices, index)
+		}
+	}
+
+	first := walk(1234)
+	if !slices.Equal(first, walk(1234)) {
+		t.Fatal("same seed produced different orders")
+	}
+
+	if slices.Equal(first, walk(4321)) {
+		t.Fatal("different seeds produced the same order")
+	}
+
+	if slices.IsSorted(first) {
+		t.Fatal("random order is sorted")
+	}
+}
+
+func TestRandomOrderSkipsExcluded(t *testing.T) {
+	targets := NewRangeSet([]Range{{Start: 0x0A000000 - 512, End: 0x0A000000 + 511}})
+	iterator := NewTargetIterator(Targets{IPv4: targets}, Exclusions{IPv4: ReservedSet}, []uint16{80}, false, NewCycle(targets.Size(), 7, NO_SHARD))
+
+	emitted := 0
+	for {
+		target, ok := iterator.Next()
+		if !ok {
+			break
+		}
+
+		ip := addrTo4(target.Addr())
+
+		if ReservedSet.Contains(ip) {
+			t.Fatalf("reserved address %s was emitted", ParseAddress(ip, 0))
+		}
+
+		emitted++
+	}
+
+	if emitted != 512 {
+		t.Fatalf("expected 512 addresses, got %d", emitted)
+	}
+}
+
+func TestShardsDisjointAndComplete(t *testing.T) {
+	targets := NewRangeSet([]Range{
+		{Start: 0x0A000000 - 700, End: 0x0A000000 + 300},
+		{Start: 0x01020300, End: 0x010203FF},
+		{Start: 0x08080808, End: 0x08080808},
+	})
+
+	// Addresses outside of the reserved 10.0.0.0/8
+	expected := targets.Size() - 301
+
+	for _, name := range []string{"sequential", "random"} {
+		for _, shards := range []uint64{1, 2, 3, 7} {
+			for _, subShards := range []uint64{1, 4} {
+				seen := make(map[uint32]Shard)
+
+				for i := range shards {
+					shard := Shard{Index: i, Count: shards}
+
+					for j := range subShards {
+						subShard := shard.Split(j, subShards)
+
+						order, err := NewOrder(name, targets.Size(), 99, subShard)
+						if err != nil {
+							t.Fatal(err)
+						}
+
+						iterator := NewTargetIterator(Targets{IPv4: targets}, Exclusions{IPv4: ReservedSet}, []uint16{80}, false, order)
+						for {
+							target, ok := iterator.Next()
+							if !ok {
+								break
+							}
+
+							ip := addrTo4(target.Addr())
+
+							if other, found := seen[ip]; found {
+								t.Fatalf("%s %d/%d: %s visited by %s and %s", name, shards, subShards, ParseAddress(ip, 0), other, subShard)
+							}
+
+							seen[ip] = subShard
+						}
+					}
+				}
+
+				if uint64(len(seen)) != expected {
+					t.Fatalf("%s %d/%d: expected %d addresses, got %d", name, shards, subShards, expected, len(seen))
+				}
+			}
+		}
+	}
+}
+
+func TestParseShard(t *testing.T) {
+	shard, err := ParseShard("2/5")
+	if err != nil || shard != (Shard{Index: 2, Count: 5}) {
+		t.Fatalf("expected 2/5, got %s (%v)", shard, err)
+	}
+
+	for _, invalid := range []string{"", "1", "5/5", "1/0", "a/2", "1/b", "-1/2"} {
+		_, err := ParseShard(invalid)
+		if err == nil {
+			t.Errorf("expected '%s' to be invalid", invalid)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package targets
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+)
+
+// Entry of the IANA IPv4 special-purpose address registry, the tables in reserved_table.go are generated
+// from the registry CSV.
+//
+// https://www.iana.org/assignments/iana-ipv4-special-registry/iana-ipv4-special-registry.xhtml
+type SpecialPurpose struct {
+	// CIDR of the block
+	Prefix string
+	Name   string
+	RFC    string
+}
+
+//go:generate go run gen_reserved.go
+
+// The multicast block is not part of the special-purpose registry, it comes from the
+// IPv4 multicast address space registry.
+//
+// https://www.iana.org/assignments/multicast-addresses/multicast-addresses.xhtml
+var MULTICAST = SpecialPurpose{Prefix: "224.0.0.0/4", Name: "Multicast", RFC: "RFC 5771"}
+
+// Addresses that can never answer a scan, excluded by default
+var ReservedSet = newReservedSet(append(slices.Clone(SPECIAL_PURPOSE_REGISTRY), MULTICAST), GLOBALLY_REACHABLE)
+
+// Every block of reserved without the addresses of reachable
+func newReservedSet(reserved []SpecialPurpose, reachable []SpecialPurpose) RangeSet {
+	blocks := registryRanges(reserved)
+	holes := registryRanges(reachable)
+
+	var ranges []Range
+	for _, block := range blocks.Ranges() {
+		start := uint64(block.Start)
+
+		for _, hole := range holes.Ranges() {
+			if hole.End < block.Start || hole.Start > block.End {
+				continue
+			}
+
+			if uint64(hole.Start) > start {
+				ranges = append(ranges, Range{Start: uint32(start), End: hole.Start - 1})
+			}
+
+			start = uint64(hole.End) + 1
+		}
+
+		if start <= uint64(block.End) {
+			ranges = append(ranges, Range{Start: uint32(start), End: block.End})
+		}
+	}
+
+	return NewRangeSet(ranges)
+}
+
+func registryRanges(registry []SpecialPurpose) RangeSet {
+	ranges := make([]Range, len(registry))
+
+	for i, entry := range registry {
+		prefix, err := netip.ParsePrefix(entry.Prefix)
+		if err != nil || !prefix.Addr().Is4() {
+			panic(fmt.Sprintf("invalid special-purpose block '%s'", entry.Prefix))
+		}
+
+		r, err := parseTarget(prefix.String())
+		if err != nil {
+			panic(fmt.Sprintf("invalid special-purpose block '%s': %s", entry.Prefix, err))
+		}
+
+		ranges[i] = r
+	}
+
+	return NewRangeSet(ranges)
+}
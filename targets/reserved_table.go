@@ -0,0 +1,37 @@
+// Code generated by gen_reserved.go from testdata/iana-ipv4-special-registry-1.csv; DO NOT EDIT.
+
+package targets
+
+// Blocks of the IANA IPv4 special-purpose address registry that are not globally reachable
+var SPECIAL_PURPOSE_REGISTRY = []SpecialPurpose{
+	{Prefix: "0.0.0.0/8", Name: "This network", RFC: "RFC 791, Section 3.2"},
+	{Prefix: "0.0.0.0/32", Name: "This host on this network", RFC: "RFC 1122, Section 3.2.1.3"},
+	{Prefix: "10.0.0.0/8", Name: "Private-Use", RFC: "RFC 1918"},
+	{Prefix: "100.64.0.0/10", Name: "Shared Address Space", RFC: "RFC 6598"},
+	{Prefix: "127.0.0.0/8", Name: "Loopback", RFC: "RFC 1122, Section 3.2.1.3"},
+	{Prefix: "169.254.0.0/16", Name: "Link Local", RFC: "RFC 3927"},
+	{Prefix: "172.16.0.0/12", Name: "Private-Use", RFC: "RFC 1918"},
+	{Prefix: "192.0.0.0/24", Name: "IETF Protocol Assignments", RFC: "RFC 6890, Section 2.1"},
+	{Prefix: "192.0.0.0/29", Name: "IPv4 Service Continuity Prefix", RFC: "RFC 7335"},
+	{Prefix: "192.0.0.8/32", Name: "IPv4 dummy address", RFC: "RFC 7600"},
+	{Prefix: "192.0.0.170/32", Name: "NAT64/DNS64 Discovery", RFC: "RFC 8880, RFC 7050, Section 2.2"},
+	{Prefix: "192.0.0.171/32", Name: "NAT64/DNS64 Discovery", RFC: "RFC 8880, RFC 7050, Section 2.2"},
+	{Prefix: "192.0.2.0/24", Name: "Documentation (TEST-NET-1)", RFC: "RFC 5737"},
+	{Prefix: "192.88.99.2/32", Name: "6a44-relay anycast address", RFC: "RFC 6751"},
+	{Prefix: "192.168.0.0/16", Name: "Private-Use", RFC: "RFC 1918"},
+	{Prefix: "198.18.0.0/15", Name: "Benchmarking", RFC: "RFC 2544"},
+	{Prefix: "198.51.100.0/24", Name: "Documentation (TEST-NET-2)", RFC: "RFC 5737"},
+	{Prefix: "203.0.113.0/24", Name: "Documentation (TEST-NET-3)", RFC: "RFC 5737"},
+	{Prefix: "240.0.0.0/4", Name: "Reserved", RFC: "RFC 1112, Section 4"},
+	{Prefix: "255.255.255.255/32", Name: "Limited Broadcast", RFC: "RFC 8190, RFC 919, Section 7"},
+}
+
+// Blocks of the IANA IPv4 special-purpose address registry that are globally reachable, they stay
+// scannable even when nested inside a block of SPECIAL_PURPOSE_REGISTRY
+var GLOBALLY_REACHABLE = []SpecialPurpose{
+	{Prefix: "192.0.0.9/32", Name: "Port Control Protocol Anycast", RFC: "RFC 7723"},
+	{Prefix: "192.0.0.10/32", Name: "Traversal Using Relays around NAT Anycast", RFC: "RFC 8155"},
+	{Prefix: "192.31.196.0/24", Name: "AS112-v4", RFC: "RFC 7535"},
+	{Prefix: "192.52.193.0/24", Name: "AMT", RFC: "RFC 7450"},
+	{Prefix: "192.175.48.0/24", Name: "Direct Delegation AS112 Service", RFC: "RFC 7534"},
+}
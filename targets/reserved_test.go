@@ -0,0 +1,171 @@
+package targets
+
+import (
+	"math"
+	"net/netip"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/Kyagara/hagelslag/targets/internal/iana"
+)
+
+func TestReservedSetBoundaries(t *testing.T) {
+	parse := func(registry []SpecialPurpose) []Range {
+		blocks := make([]Range, len(registry))
+		for i, entry := range registry {
+			block, err := parseTarget(entry.Prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			blocks[i] = block
+		}
+
+		return blocks
+	}
+
+	registry := append(slices.Clone(SPECIAL_PURPOSE_REGISTRY), MULTICAST)
+	blocks := parse(registry)
+	reachable := parse(GLOBALLY_REACHABLE)
+
+	inside := func(ip uint32, list []Range) bool {
+		for _, block := range list {
+			if ip >= block.Start && ip <= block.End {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	// Inside a block and not carved out of it
+	reserved := func(ip uint32) bool {
+		return inside(ip, blocks) && !inside(ip, reachable)
+	}
+
+	for i, entry := range registry {
+		block := blocks[i]
+
+		t.Run(entry.Prefix, func(t *testing.T) {
+			for _, ip := range []uint32{block.Start, block.Start + 1, block.End - 1, block.End} {
+				if ip < block.Start || ip > block.End {
+					continue
+				}
+
+				if ReservedSet.Contains(ip) != reserved(ip) {
+					t.Errorf("%s (%s) has the wrong reserved status", ParseAddress(ip, 0), entry.Name)
+				}
+			}
+
+			if block.Start > 0 {
+				before := block.Start - 1
+				if ReservedSet.Contains(before) != reserved(before) {
+					t.Errorf("%s before %s has the wrong reserved status", ParseAddress(before, 0), entry.Prefix)
+				}
+			}
+
+			if block.End < math.MaxUint32 {
+				after := block.End + 1
+				if ReservedSet.Contains(after) != reserved(after) {
+					t.Errorf("%s after %s has the wrong reserved status", ParseAddress(after, 0), entry.Prefix)
+				}
+			}
+
+			// Walking around the block should only emit the addresses that are not reserved
+			start := uint32(max(int64(block.Start)-2, 0))
+			end := uint32(min(int64(block.End)+2, math.MaxUint32))
+
+			targets := NewRangeSet([]Range{{Start: start, End: end}})
+			iterator := NewTargetIterator(Targets{IPv4: targets}, Exclusions{IPv4: ReservedSet}, []uint16{80}, false, NewSequential(targets.Size(), NO_SHARD))
+			for {
+				target, ok := iterator.Next()
+				if !ok {
+					break
+				}
+
+				ip := addrTo4(target.Addr())
+				if reserved(ip) {
+					t.Fatalf("%s (%s) was emitted but is reserved", ParseAddress(ip, 0), entry.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestReservedSetScannable(t *testing.T) {
+	scannable := []string{
+		"1.0.0.0",
+		"9.255.255.255",
+		"11.0.0.0",
+		"100.63.255.255",
+		"100.128.0.0",
+		"172.15.255.255",
+		"172.32.0.0",
+		"192.0.0.9",
+		"192.0.0.10",
+		"192.0.1.0",
+		"192.0.3.0",
+		"192.31.196.1",
+		"192.52.193.1",
+		"192.88.99.1",
+		"192.175.48.1",
+		"198.17.255.255",
+		"198.20.0.0",
+		"223.255.255.255",
+	}
+
+	for _, address := range scannable {
+		ip, err := ParseIP(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ReservedSet.Contains(ip) {
+			t.Errorf("%s should be scannable", address)
+		}
+	}
+}
+
+func TestSpecialPurposePrefixes(t *testing.T) {
+	for _, entry := range append(append(slices.Clone(SPECIAL_PURPOSE_REGISTRY), MULTICAST), GLOBALLY_REACHABLE...) {
+		prefix, err := netip.ParsePrefix(entry.Prefix)
+		if err != nil || prefix != prefix.Masked() {
+			t.Errorf("%s (%s) is not a valid prefix", entry.Prefix, entry.Name)
+		}
+	}
+}
+
+func TestReservedTableMatchesRegistry(t *testing.T) {
+	file, err := os.Open("testdata/iana-ipv4-special-registry-1.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	entries, err := iana.ParseSpecialRegistry(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reserved, reachable []SpecialPurpose
+	for _, entry := range entries {
+		block := SpecialPurpose{Prefix: entry.Prefix.String(), Name: entry.Name, RFC: entry.RFC}
+
+		switch entry.GloballyReachable {
+		case "False":
+			reserved = append(reserved, block)
+		case "True":
+			reachable = append(reachable, block)
+		}
+	}
+
+	// Run 'go generate' in targets when this fails
+	if !slices.Equal(reserved, SPECIAL_PURPOSE_REGISTRY) {
+		t.Errorf("SPECIAL_PURPOSE_REGISTRY is out of date with the registry, expected %v", reserved)
+	}
+
+	if !slices.Equal(reachable, GLOBALLY_REACHABLE) {
+		t.Errorf("GLOBALLY_REACHABLE is out of date with the registry, expected %v", reachable)
+	}
+}
@@ -0,0 +1,77 @@
+package targets
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestResumeFromLowWaterMark(t *testing.T) {
+	targets := Targets{IPv4: NewRangeSet([]Range{{Start: 0x01000000, End: 0x010003FF}})}
+	excluded := Exclusions{IPv4: NewRangeSet([]Range{{Start: 0x01000100, End: 0x010001FF}})}
+	ports := []uint16{80, 8080}
+	size := targets.Size() * uint64(len(ports))
+
+	for _, name := range []string{"sequential", "random"} {
+		order, err := NewOrder(name, size, 5, NO_SHARD)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		iterator := NewTargetIterator(targets, excluded, ports, false, order)
+		scanned := make(map[netip.AddrPort]int)
+
+		// Keep a window of in flight targets, completing them out of order
+		var inFlight []netip.AddrPort
+		for i := range 500 {
+			target, ok := iterator.Next()
+			if !ok {
+				t.Fatal("walk ended too early")
+			}
+
+			inFlight = append(inFlight, target)
+			if len(inFlight) == 8 {
+				done := inFlight[i%3]
+				iterator.Done(done)
+				scanned[done]++
+				inFlight = slices.Delete(inFlight, i%3, i%3+1)
+			}
+		}
+
+		// Crash, anything still in flight is lost
+		checkpoint := iterator.LowWaterMark()
+
+		order, err = NewOrder(name, size, 5, NO_SHARD)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resumed := NewTargetIterator(targets, excluded, ports, false, order)
+		resumed.SetPosition(checkpoint)
+
+		for {
+			target, ok := resumed.Next()
+			if !ok {
+				break
+			}
+
+			scanned[target]++
+		}
+
+		expected := (targets.Size() - 256) * uint64(len(ports))
+		if uint64(len(scanned)) != expected {
+			t.Fatalf("%s: expected %d targets to be scanned, got %d", name, expected, len(scanned))
+		}
+
+		rescanned := 0
+		for _, count := range scanned {
+			if count > 1 {
+				rescanned++
+			}
+		}
+
+		if rescanned > 16 {
+			t.Fatalf("%s: %d targets were scanned again", name, rescanned)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package targets
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Targets read from a collection or a file, used to rescan hosts that were already found.
+//
+// Entries are read in the background as the targets are consumed, Next can be called from any goroutine.
+// Stop ends the reading when the targets left are not needed.
+type Stream struct {
+	targets  chan netip.AddrPort
+	ports    []uint16
+	excluded Exclusions
+
+	// Called with the entries that couldn't be read, can be nil
+	onError func(error)
+
+	// Canceled by Stop
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Amount of entries read and the total amount of entries
+	position atomic.Uint64
+	total    atomic.Uint64
+}
+
+// Stream of total entries, the targets of each entry are sent with Push.
+// Errors reading the entries are passed to onError, which can be called from any goroutine.
+func NewStream(total uint64, ports []uint16, excluded Exclusions, onError func(error)) *Stream {
+	s := &Stream{
+		targets:  make(chan netip.AddrPort, 1024),
+		ports:    ports,
+		excluded: excluded,
+		onError:  onError,
+	}
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	s.total.Store(total)
+	return s
+}
+
+// Counts an entry that couldn't be read
+func (s *Stream) Skip() {
+	s.position.Add(1)
+}
+
+// Passes an error reading the entries to the caller of the stream
+func (s *Stream) Report(err error) {
+	if s.onError != nil {
+		s.onError(err)
+	}
+}
+
+// Ends the stream once the pushed targets are consumed, must be called once every entry was pushed
+// or once the stream was stopped
+func (s *Stream) Close() {
+	close(s.targets)
+}
+
+// Stops reading the entries, the targets not consumed yet are dropped. Push returns false from now on.
+func (s *Stream) Stop() {
+	s.cancel()
+}
+
+// Canceled once the stream is stopped, the reads of the entries should use it
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+// Returns the next target, false when every entry was read
+func (s *Stream) Next() (netip.AddrPort, bool) {
+	target, ok := <-s.targets
+	return target, ok
+}
+
+// Entries are not tracked, a rescan can't be resumed
+func (s *Stream) Done(target netip.AddrPort) {}
+
+// Amount of entries already read and the total amount of entries
+func (s *Stream) Progress() (uint64, uint64) {
+	return s.position.Load(), s.total.Load()
+}
+
+// Sends the targets of an entry, excluded addresses are skipped.
+// Blocks while the targets were not consumed, returns false if the stream was stopped first.
+func (s *Stream) Push(entry string) bool {
+	s.position.Add(1)
+
+	targets, err := parseRescanTarget(entry, s.ports)
+	if err != nil {
+		s.Report(err)
+		return s.ctx.Err() == nil
+	}
+
+	for _, target := range targets {
+		if s.excluded.Contains(target.Addr()) {
+			continue
+		}
+
+		select {
+		case s.targets <- target:
+		case <-s.ctx.Done():
+			return false
+		}
+	}
+
+	return true
+}
+
+// Streams the addresses in a file in the connections.out format, one address per line with an optional port.
+// Lines that can't be parsed are passed to onError.
+func StreamFile(path string, ports []uint16, excluded Exclusions, onError func(error)) (*Stream, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %s", err)
+	}
+
+	total, err := countLines(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read file: %s", err)
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read file: %s", err)
+	}
+
+	s := NewStream(total, ports, excluded, onError)
+
+	go func() {
+		defer s.Close()
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			entry, _, _ := strings.Cut(scanner.Text(), "#")
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				s.Skip()
+				continue
+			}
+
+			if !s.Push(entry) {
+				return
+			}
+		}
+
+		err := scanner.Err()
+		if err != nil {
+			s.Report(fmt.Errorf("failed to read '%s': %s", path, err))
+		}
+	}()
+
+	return s, nil
+}
+
+// Parses an '_id' or a line of connections.out, an address without port is rescanned on every port
+func parseRescanTarget(entry string, ports []uint16) ([]netip.AddrPort, error) {
+	target, err := netip.ParseAddrPort(entry)
+	if err == nil {
+		return []netip.AddrPort{netip.AddrPortFrom(target.Addr().Unmap(), target.Port())}, nil
+	}
+
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target '%s'", entry)
+	}
+
+	targets := make([]netip.AddrPort, len(ports))
+	for i, port := range ports {
+		targets[i] = netip.AddrPortFrom(addr.Unmap(), port)
+	}
+
+	return targets, nil
+}
+
+func countLines(r io.Reader) (uint64, error) {
+	buffer := make([]byte, 64*1024)
+	lines := uint64(0)
+	last := byte('\n')
+
+	for {
+		n, err := r.Read(buffer)
+		if n > 0 {
+			lines += uint64(bytes.Count(buffer[:n], []byte{'\n'}))
+			last = buffer[n-1]
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Last line without a newline
+	if last != '\n' {
+		lines++
+	}
+
+	return lines, nil
+}
@@ -0,0 +1,113 @@
+package targets
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "connections.out")
+
+	content := `1.1.1.1
+1.1.1.2:8080
+[2001:db8::1]:25565
+10.0.0.1
+not an address
+# comment
+
+2001:db8::2`
+
+	err := os.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	excluded, err := LoadExclusions(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only read once the stream is closed
+	var reported []error
+	stream, err := StreamFile(path, []uint16{80, 443}, excluded, func(err error) { reported = append(reported, err) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var emitted []string
+	for {
+		target, ok := stream.Next()
+		if !ok {
+			break
+		}
+
+		emitted = append(emitted, FormatTarget(target))
+	}
+
+	expected := []string{"1.1.1.1:80", "1.1.1.1:443", "1.1.1.2:8080", "[2001:db8::1]:25565", "[2001:db8::2]:80", "[2001:db8::2]:443"}
+	if !slices.Equal(emitted, expected) {
+		t.Fatalf("expected %v, got %v", expected, emitted)
+	}
+
+	if len(reported) != 1 || !strings.Contains(reported[0].Error(), "not an address") {
+		t.Fatalf("expected the invalid line to be reported, got %v", reported)
+	}
+
+	position, total := stream.Progress()
+	if position != 8 || total != 8 {
+		t.Fatalf("expected progress 8/8, got %d/%d", position, total)
+	}
+}
+
+func TestStreamStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "connections.out")
+
+	// More targets than the stream holds, the reader waits for them to be consumed
+	var content strings.Builder
+	for i := range 5000 {
+		fmt.Fprintf(&content, "10.0.%d.%d\n", i/256, i%256)
+	}
+
+	err := os.WriteFile(path, []byte(content.String()), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := StreamFile(path, []uint16{80}, Exclusions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 10 {
+		stream.Next()
+	}
+
+	stream.Stop()
+
+	// The targets are closed once the reader returned
+	done := make(chan struct{})
+	go func() {
+		for {
+			_, ok := stream.Next()
+			if !ok {
+				close(done)
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the reader to return once the stream was stopped")
+	}
+
+	if position, _ := stream.Progress(); position == 5000 {
+		t.Fatalf("expected the reader to stop before the end of the file")
+	}
+}
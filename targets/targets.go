@@ -0,0 +1,648 @@
+package targets
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Inclusive range of IPv4 addresses
+type Range struct {
+	Start uint32
+	End   uint32
+}
+
+// Amount of addresses in the range
+func (r Range) Size() uint64 {
+	return uint64(r.End) - uint64(r.Start) + 1
+}
+
+// Sorted set of non overlapping ranges, addresses are accessed by their index in the set
+type RangeSet struct {
+	ranges []Range
+	// Amount of addresses before each range
+	offsets []uint64
+	total   uint64
+}
+
+// Sorts and merges overlapping or adjacent ranges
+func NewRangeSet(ranges []Range) RangeSet {
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a Range, b Range) int {
+		if a.Start < b.Start {
+			return -1
+		}
+
+		if a.Start > b.Start {
+			return 1
+		}
+
+		return 0
+	})
+
+	merged := make([]Range, 0, len(sorted))
+	for _, r := range sorted {
+		if len(merged) > 0 {
+			last := &merged[len(merged)-1]
+			if uint64(r.Start) <= uint64(last.End)+1 {
+				last.End = max(last.End, r.End)
+				continue
+			}
+		}
+
+		merged = append(merged, r)
+	}
+
+	t := RangeSet{
+		ranges:  merged,
+		offsets: make([]uint64, len(merged)),
+	}
+
+	for i, r := range merged {
+		t.offsets[i] = t.total
+		t.total += r.Size()
+	}
+
+	return t
+}
+
+// Amount of addresses in the set
+func (t RangeSet) Size() uint64 {
+	return t.total
+}
+
+// Ranges in the set, sorted and merged
+func (t RangeSet) Ranges() []Range {
+	return t.ranges
+}
+
+// Returns the address at index i, i must be lower than Size
+func (t RangeSet) At(i uint64) uint32 {
+	// First range that starts after i
+	n, _ := slices.BinarySearch(t.offsets, i+1)
+
+	r := n - 1
+	return t.ranges[r].Start + uint32(i-t.offsets[r])
+}
+
+// Returns the index of the first address in the set that is equal or greater than ip, Size if there is none
+func (t RangeSet) Index(ip uint32) uint64 {
+	i := t.search(ip)
+	if i == len(t.ranges) {
+		return t.total
+	}
+
+	r := t.ranges[i]
+	if ip <= r.Start {
+		return t.offsets[i]
+	}
+
+	return t.offsets[i] + uint64(ip-r.Start)
+}
+
+// Returns the range containing ip, false if ip is not in the set
+func (t RangeSet) Find(ip uint32) (Range, bool) {
+	i := t.search(ip)
+	if i == len(t.ranges) || ip < t.ranges[i].Start {
+		return Range{}, false
+	}
+
+	return t.ranges[i], true
+}
+
+// Reports whether ip is in the set
+func (t RangeSet) Contains(ip uint32) bool {
+	_, found := t.Find(ip)
+	return found
+}
+
+// Returns the addresses in the set that are equal or lower than end
+func (t RangeSet) Clip(end uint32) RangeSet {
+	var ranges []Range
+
+	for _, r := range t.ranges {
+		if r.Start > end {
+			break
+		}
+
+		r.End = min(r.End, end)
+		ranges = append(ranges, r)
+	}
+
+	return NewRangeSet(ranges)
+}
+
+// Returns the position of the first range that ends at or after ip
+func (t RangeSet) search(ip uint32) int {
+	i, _ := slices.BinarySearchFunc(t.ranges, ip, func(r Range, target uint32) int {
+		if r.End < target {
+			return -1
+		}
+
+		if r.End > target {
+			return 1
+		}
+
+		return 0
+	})
+
+	return i
+}
+
+// Sorted set of IPv6 prefixes, a prefix nested inside another one is merged into it
+type PrefixSet struct {
+	prefixes []netip.Prefix
+}
+
+// Sorts the prefixes and drops the nested ones, the prefixes left don't overlap
+func NewPrefixSet(prefixes []netip.Prefix) PrefixSet {
+	sorted := make([]netip.Prefix, len(prefixes))
+	for i, prefix := range prefixes {
+		sorted[i] = prefix.Masked()
+	}
+
+	// By first address, the larger prefix first
+	slices.SortFunc(sorted, func(a netip.Prefix, b netip.Prefix) int {
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c
+		}
+
+		return a.Bits() - b.Bits()
+	})
+
+	merged := make([]netip.Prefix, 0, len(sorted))
+	for _, prefix := range sorted {
+		if len(merged) > 0 && merged[len(merged)-1].Contains(prefix.Addr()) {
+			continue
+		}
+
+		merged = append(merged, prefix)
+	}
+
+	return PrefixSet{prefixes: merged}
+}
+
+// Prefixes in the set, sorted and without nested ones
+func (p PrefixSet) Prefixes() []netip.Prefix {
+	return p.prefixes
+}
+
+// Reports whether addr is inside one of the prefixes
+func (p PrefixSet) Contains(addr netip.Addr) bool {
+	// First prefix that starts after addr, only the one before it can contain addr
+	i, _ := slices.BinarySearchFunc(p.prefixes, addr, func(prefix netip.Prefix, target netip.Addr) int {
+		if prefix.Addr().Compare(target) <= 0 {
+			return -1
+		}
+
+		return 1
+	})
+
+	return i > 0 && p.prefixes[i-1].Contains(addr)
+}
+
+// Addresses to scan, IPv4 ranges followed by a list of IPv6 addresses
+type Targets struct {
+	IPv4 RangeSet
+	// Sorted, without duplicates
+	IPv6 []netip.Addr
+}
+
+// Amount of addresses in the targets
+func (t Targets) Size() uint64 {
+	return t.IPv4.Size() + uint64(len(t.IPv6))
+}
+
+// Returns the address at index i, i must be lower than Size
+func (t Targets) At(i uint64) netip.Addr {
+	if i < t.IPv4.Size() {
+		return AddrFrom4(t.IPv4.At(i))
+	}
+
+	return t.IPv6[i-t.IPv4.Size()]
+}
+
+// Returns the targets that are equal or lower than end, IPv4 and IPv6 targets are clipped separately
+func (t Targets) Clip(end netip.Addr) Targets {
+	if end.Is4() {
+		return Targets{IPv4: t.IPv4.Clip(addrTo4(end)), IPv6: t.IPv6}
+	}
+
+	i, found := slices.BinarySearchFunc(t.IPv6, end, netip.Addr.Compare)
+	if found {
+		i++
+	}
+
+	return Targets{IPv4: t.IPv4, IPv6: t.IPv6[:i]}
+}
+
+// Addresses that should not be scanned
+type Exclusions struct {
+	IPv4 RangeSet
+	IPv6 PrefixSet
+}
+
+// Reports whether addr is excluded
+func (e Exclusions) Contains(addr netip.Addr) bool {
+	if addr.Is4() {
+		return e.IPv4.Contains(addrTo4(addr))
+	}
+
+	return e.IPv6.Contains(addr)
+}
+
+// Provides the targets to scan
+type Source interface {
+	// Returns the next target, false when there are no targets left
+	Next() (netip.AddrPort, bool)
+	// Marks a target returned by Next as completed
+	Done(target netip.AddrPort)
+	// Amount of targets already visited and the total amount of targets
+	Progress() (uint64, uint64)
+}
+
+// Source reading its targets in the background, like a Stream. Stopped once no more targets are taken
+// from it, its reader is released.
+type StoppableSource interface {
+	Source
+	Stop()
+}
+
+// Walks every (address, port) pair of the targets in the given order, skipping excluded addresses.
+//
+// Targets returned by Next are pending until Done is called, the low-water mark is the position of the
+// first pending target, every step of the walk before it is completed and the walk can be resumed from it.
+//
+// Next and SetPosition must only be called by one goroutine, every other method can be called from any goroutine.
+type TargetIterator struct {
+	targets  Targets
+	excluded Exclusions
+	ports    []uint16
+	// Visit every address for each port instead of every port for each address
+	portMajor bool
+	order     Order
+
+	// Updated on every call to Next
+	position atomic.Uint64
+	total    atomic.Uint64
+	// Position of the order after the last call to Next
+	walked atomic.Uint64
+
+	mu sync.Mutex
+	// Steps of the targets returned by Next that are not done, in the order they were returned
+	pending []uint64
+	steps   map[netip.AddrPort]uint64
+	// Steps done while an earlier step is still pending
+	done map[uint64]bool
+}
+
+// The order must cover targets.Size() * len(ports) indices
+func NewTargetIterator(targets Targets, excluded Exclusions, ports []uint16, portMajor bool, order Order) *TargetIterator {
+	it := &TargetIterator{
+		targets:   targets,
+		excluded:  excluded,
+		ports:     ports,
+		portMajor: portMajor,
+		order:     order,
+		steps:     make(map[netip.AddrPort]uint64),
+		done:      make(map[uint64]bool),
+	}
+
+	it.updateProgress()
+	return it
+}
+
+// Returns the next target, false when all targets were visited
+func (it *TargetIterator) Next() (netip.AddrPort, bool) {
+	defer it.updateProgress()
+
+	hosts := it.targets.Size()
+	ports := uint64(len(it.ports))
+
+	for {
+		index, ok := it.order.Next()
+		if !ok {
+			return netip.AddrPort{}, false
+		}
+
+		host, port := index/ports, index%ports
+		if it.portMajor {
+			host, port = index%hosts, index/hosts
+		}
+
+		if host >= it.targets.IPv4.Size() {
+			addr := it.targets.At(host)
+			if it.excluded.Contains(addr) {
+				continue
+			}
+
+			return it.issue(netip.AddrPortFrom(addr, it.ports[port])), true
+		}
+
+		ip := it.targets.IPv4.At(host)
+
+		block, excluded := it.excluded.IPv4.Find(ip)
+		if !excluded {
+			return it.issue(netip.AddrPortFrom(AddrFrom4(ip), it.ports[port])), true
+		}
+
+		// Sequential walks can jump past the whole block
+		if sequential, ok := it.order.(*Sequential); ok {
+			next := it.targets.IPv4.Size()
+			if block.End != math.MaxUint32 {
+				next = it.targets.IPv4.Index(block.End + 1)
+			}
+
+			if it.portMajor {
+				sequential.Seek(port*hosts + next)
+			} else {
+				sequential.Seek(next * ports)
+			}
+		}
+	}
+}
+
+// Amount of probes already visited (including excluded ones) and the total amount of probes
+func (it *TargetIterator) Progress() (uint64, uint64) {
+	return it.position.Load(), it.total.Load()
+}
+
+// Marks a target returned by Next as completed
+func (it *TargetIterator) Done(target netip.AddrPort) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	step, found := it.steps[target]
+	if !found {
+		return
+	}
+
+	delete(it.steps, target)
+
+	if step != it.pending[0] {
+		it.done[step] = true
+		return
+	}
+
+	it.pending = it.pending[1:]
+	for len(it.pending) > 0 && it.done[it.pending[0]] {
+		delete(it.done, it.pending[0])
+		it.pending = it.pending[1:]
+	}
+}
+
+// Position of the first step that is not completed
+func (it *TargetIterator) LowWaterMark() uint64 {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	if len(it.pending) > 0 {
+		return it.pending[0]
+	}
+
+	return it.walked.Load()
+}
+
+// Moves the walk to position, used to resume a walk from its low-water mark
+func (it *TargetIterator) SetPosition(position uint64) {
+	it.order.SetPosition(position)
+	it.updateProgress()
+}
+
+// Registers the target as pending
+func (it *TargetIterator) issue(target netip.AddrPort) netip.AddrPort {
+	// The order already moved past the step of the target
+	step := it.order.Position() - 1
+
+	it.mu.Lock()
+	it.pending = append(it.pending, step)
+	it.steps[target] = step
+	it.mu.Unlock()
+
+	return target
+}
+
+func (it *TargetIterator) updateProgress() {
+	position, total := it.order.Progress()
+	it.position.Store(position)
+	it.total.Store(total)
+	it.walked.Store(it.order.Position())
+}
+
+// Parses an IPv4 target, which can be a CIDR (1.2.3.0/24), a range (1.2.3.4-1.2.3.10) or a single address
+func parseTarget(spec string) (Range, error) {
+	if address, bits, found := strings.Cut(spec, "/"); found {
+		if address == "" {
+			return Range{}, fmt.Errorf("invalid CIDR '%s'", spec)
+		}
+
+		ip, err := ParseIP(address)
+		if err != nil {
+			return Range{}, err
+		}
+
+		prefix, err := strconv.Atoi(bits)
+		if err != nil || prefix < 0 || prefix > 32 {
+			return Range{}, fmt.Errorf("invalid prefix length '%s' in CIDR '%s'", bits, spec)
+		}
+
+		mask := uint32(0)
+		if prefix > 0 {
+			mask = ^uint32(0) << (32 - prefix)
+		}
+
+		return Range{Start: ip & mask, End: ip | ^mask}, nil
+	}
+
+	if first, last, found := strings.Cut(spec, "-"); found {
+		if first == "" || last == "" {
+			return Range{}, fmt.Errorf("invalid range '%s'", spec)
+		}
+
+		start, err := ParseIP(first)
+		if err != nil {
+			return Range{}, err
+		}
+
+		end, err := ParseIP(last)
+		if err != nil {
+			return Range{}, err
+		}
+
+		if end < start {
+			return Range{}, fmt.Errorf("invalid range '%s', end is lower than start", spec)
+		}
+
+		return Range{Start: start, End: end}, nil
+	}
+
+	ip, err := ParseIP(spec)
+	if err != nil {
+		return Range{}, err
+	}
+
+	return Range{Start: ip, End: ip}, nil
+}
+
+// Targets as they were parsed, IPv4 as ranges and IPv6 as prefixes
+type targetList struct {
+	ranges   []Range
+	prefixes []netip.Prefix
+}
+
+// Parses spec as an IPv6 address or prefix if it contains a ':', otherwise as an IPv4 target
+func (l *targetList) add(spec string) error {
+	if !strings.Contains(spec, ":") {
+		target, err := parseTarget(spec)
+		if err != nil {
+			return err
+		}
+
+		l.ranges = append(l.ranges, target)
+		return nil
+	}
+
+	var prefix netip.Prefix
+	if strings.Contains(spec, "/") {
+		parsed, err := netip.ParsePrefix(spec)
+		if err != nil {
+			return fmt.Errorf("invalid IPv6 prefix '%s'", spec)
+		}
+
+		prefix = parsed.Masked()
+	} else {
+		addr, err := netip.ParseAddr(spec)
+		if err != nil {
+			return fmt.Errorf("invalid IPv6 address '%s'", spec)
+		}
+
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+
+	// IPv4-mapped addresses (::ffff:1.2.3.4) are treated as IPv4
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		start := addrTo4(prefix.Addr().Unmap())
+		end := start | uint32(1<<(128-prefix.Bits())-1)
+		l.ranges = append(l.ranges, Range{Start: start, End: end})
+		return nil
+	}
+
+	l.prefixes = append(l.prefixes, prefix)
+	return nil
+}
+
+// Reads targets from r into list, one per line. Empty lines and everything after a '#' are ignored.
+func readTargets(r io.Reader, list *targetList) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		spec, _, _ := strings.Cut(scanner.Text(), "#")
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		err := list.add(spec)
+		if err != nil {
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Parses all specs. A spec can be a target, a file containing targets or '-' to read them from stdin.
+func parseSpecs(specs []string) (targetList, error) {
+	var list targetList
+
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		if spec == "-" {
+			err := readTargets(os.Stdin, &list)
+			if err != nil {
+				return targetList{}, fmt.Errorf("failed reading targets from stdin: %s", err)
+			}
+
+			continue
+		}
+
+		if _, err := os.Stat(spec); err == nil {
+			file, err := os.Open(spec)
+			if err != nil {
+				return targetList{}, fmt.Errorf("failed to open targets file: %s", err)
+			}
+
+			err = readTargets(file, &list)
+			file.Close()
+			if err != nil {
+				return targetList{}, fmt.Errorf("failed reading targets from '%s': %s", spec, err)
+			}
+
+			continue
+		}
+
+		err := list.add(spec)
+		if err != nil {
+			return targetList{}, err
+		}
+	}
+
+	return list, nil
+}
+
+// Loads all targets from specs, IPv6 targets must be single addresses since the IPv6 space can't be walked.
+func Load(specs []string) (Targets, error) {
+	list, err := parseSpecs(specs)
+	if err != nil {
+		return Targets{}, err
+	}
+
+	addrs := make([]netip.Addr, 0, len(list.prefixes))
+	for _, prefix := range list.prefixes {
+		if !prefix.IsSingleIP() {
+			return Targets{}, fmt.Errorf("IPv6 prefix '%s' can't be walked, use a list of addresses", prefix)
+		}
+
+		addrs = append(addrs, prefix.Addr())
+	}
+
+	slices.SortFunc(addrs, netip.Addr.Compare)
+
+	return Targets{
+		IPv4: NewRangeSet(list.ranges),
+		IPv6: slices.Compact(addrs),
+	}, nil
+}
+
+// Loads all excluded addresses from specs, specs follow the same format as targets but IPv6 prefixes are allowed.
+// Addresses in ReservedSet are included if reserved is true.
+func LoadExclusions(specs []string, reserved bool) (Exclusions, error) {
+	list, err := parseSpecs(specs)
+	if err != nil {
+		return Exclusions{}, err
+	}
+
+	if reserved {
+		list.ranges = append(list.ranges, ReservedSet.Ranges()...)
+	}
+
+	return Exclusions{
+		IPv4: NewRangeSet(list.ranges),
+		IPv6: NewPrefixSet(list.prefixes),
+	}, nil
+}
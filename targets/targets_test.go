@@ -0,0 +1,315 @@
+package targets
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExcludedNeverEmitted(t *testing.T) {
+	exclude := filepath.Join(t.TempDir(), "exclude.txt")
+
+	content := `# opt-out requests
+1.0.0.0/28
+1.0.1.128-1.0.2.3 # spans two /24s
+
+1.0.3.255
+1.0.4.0/22
+`
+
+	err := os.WriteFile(exclude, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targets, err := Load([]string{"1.0.0.0/21"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	excluded, err := LoadExclusions([]string{exclude}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := targets.Size() - 16 - 132 - 1 - 1024
+
+	emitted := uint64(0)
+	iterator := NewTargetIterator(targets, excluded, []uint16{80}, false, NewSequential(targets.Size(), NO_SHARD))
+	for {
+		target, ok := iterator.Next()
+		if !ok {
+			break
+		}
+
+		if excluded.Contains(target.Addr()) {
+			t.Fatalf("excluded address %s was emitted", target.Addr())
+		}
+
+		emitted++
+	}
+
+	if emitted != expected {
+		t.Fatalf("expected %d addresses, got %d", expected, emitted)
+	}
+
+	position, total := iterator.Progress()
+	if position != total {
+		t.Fatalf("expected progress to reach %d, got %d", total, position)
+	}
+}
+
+func TestReservedExcludedByDefault(t *testing.T) {
+	targets, err := Load([]string{"172.15.255.250-172.32.0.5", "192.168.255.255-192.169.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	excluded, err := LoadExclusions(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var emitted []string
+	iterator := NewTargetIterator(targets, excluded, []uint16{80}, false, NewSequential(targets.Size(), NO_SHARD))
+	for {
+		target, ok := iterator.Next()
+		if !ok {
+			break
+		}
+
+		ip := addrTo4(target.Addr())
+
+		emitted = append(emitted, ParseAddress(ip, 80))
+	}
+
+	expected := []string{
+		"172.15.255.250:80", "172.15.255.251:80", "172.15.255.252:80",
+		"172.15.255.253:80", "172.15.255.254:80", "172.15.255.255:80",
+		"172.32.0.0:80", "172.32.0.1:80", "172.32.0.2:80",
+		"172.32.0.3:80", "172.32.0.4:80", "172.32.0.5:80",
+		"192.169.0.0:80", "192.169.0.1:80",
+	}
+
+	if len(emitted) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, emitted)
+	}
+
+	for i := range expected {
+		if emitted[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, emitted)
+		}
+	}
+}
+
+func TestExcludedUntilLastAddress(t *testing.T) {
+	targets := Targets{IPv4: NewRangeSet([]Range{{Start: 0xFFFFFF00, End: 0xFFFFFFFF}})}
+	excluded := Exclusions{IPv4: NewRangeSet([]Range{{Start: 0xFFFFFF10, End: 0xFFFFFFFF}})}
+
+	emitted := 0
+	iterator := NewTargetIterator(targets, excluded, []uint16{80}, false, NewSequential(targets.Size(), NO_SHARD))
+	for {
+		_, ok := iterator.Next()
+		if !ok {
+			break
+		}
+
+		emitted++
+	}
+
+	if emitted != 16 {
+		t.Fatalf("expected 16 addresses, got %d", emitted)
+	}
+}
+
+func TestPortOrdering(t *testing.T) {
+	targets := NewRangeSet([]Range{{Start: 0x01010100, End: 0x01010101}, {Start: 0x0A000000, End: 0x0A000000}})
+	ports := []uint16{80, 8080}
+
+	walk := func(portMajor bool) []string {
+		var emitted []string
+
+		iterator := NewTargetIterator(Targets{IPv4: targets}, Exclusions{IPv4: ReservedSet}, ports, portMajor, NewSequential(targets.Size()*2, NO_SHARD))
+		for {
+			target, ok := iterator.Next()
+			if !ok {
+				return emitted
+			}
+
+			emitted = append(emitted, FormatTarget(target))
+		}
+	}
+
+	hostMajor := []string{"1.1.1.0:80", "1.1.1.0:8080", "1.1.1.1:80", "1.1.1.1:8080"}
+	if !slices.Equal(walk(false), hostMajor) {
+		t.Fatalf("expected %v, got %v", hostMajor, walk(false))
+	}
+
+	portMajor := []string{"1.1.1.0:80", "1.1.1.1:80", "1.1.1.0:8080", "1.1.1.1:8080"}
+	if !slices.Equal(walk(true), portMajor) {
+		t.Fatalf("expected %v, got %v", portMajor, walk(true))
+	}
+}
+
+func TestParsePorts(t *testing.T) {
+	ports, err := ParsePorts("80, 8080,8000-8002,80")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []uint16{80, 8080, 8000, 8001, 8002}
+	if !slices.Equal(ports, expected) {
+		t.Fatalf("expected %v, got %v", expected, ports)
+	}
+
+	for _, invalid := range []string{"", "0", "65536", "a", "90-80", "80-", "-80"} {
+		_, err := ParsePorts(invalid)
+		if err == nil {
+			t.Errorf("expected '%s' to be invalid", invalid)
+		}
+	}
+}
+
+func TestParseAddrs(t *testing.T) {
+	addrs, err := ParseAddrs("192.0.2.1, 198.51.100.0/30,2001:db8::/127,192.0.2.1,::ffff:192.0.2.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []netip.Addr{
+		netip.MustParseAddr("192.0.2.1"),
+		netip.MustParseAddr("198.51.100.1"),
+		netip.MustParseAddr("198.51.100.2"),
+		netip.MustParseAddr("2001:db8::"),
+		netip.MustParseAddr("2001:db8::1"),
+		netip.MustParseAddr("192.0.2.9"),
+	}
+
+	if !slices.Equal(addrs, expected) {
+		t.Fatalf("expected %v, got %v", expected, addrs)
+	}
+
+	for _, invalid := range []string{"", "192.0.2", "192.0.2.0/33", "10.0.0.0/8", "a,192.0.2.1"} {
+		_, err := ParseAddrs(invalid)
+		if err == nil {
+			t.Errorf("expected '%s' to be invalid", invalid)
+		}
+	}
+}
+
+func TestIPv6Targets(t *testing.T) {
+	hitlist := filepath.Join(t.TempDir(), "hitlist.txt")
+
+	content := `# IPv6 hitlist
+2001:db8::2
+2001:db8::1
+2001:db8::1
+2001:db8:1::1 # excluded
+::ffff:1.1.1.1
+1.1.1.2
+`
+
+	err := os.WriteFile(hitlist, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targets, err := Load([]string{hitlist})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	excluded, err := LoadExclusions([]string{"2001:db8:1::/48"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var emitted []string
+	iterator := NewTargetIterator(targets, excluded, []uint16{80}, false, NewSequential(targets.Size(), NO_SHARD))
+	for {
+		target, ok := iterator.Next()
+		if !ok {
+			break
+		}
+
+		emitted = append(emitted, FormatTarget(target))
+	}
+
+	expected := []string{"1.1.1.1:80", "1.1.1.2:80", "[2001:db8::1]:80", "[2001:db8::2]:80"}
+	if !slices.Equal(emitted, expected) {
+		t.Fatalf("expected %v, got %v", expected, emitted)
+	}
+
+	_, err = Load([]string{"2001:db8::/64"})
+	if err == nil {
+		t.Fatal("expected IPv6 prefixes to be rejected as targets")
+	}
+}
+
+func TestPrefixSet(t *testing.T) {
+	var prefixes []netip.Prefix
+	for _, prefix := range []string{"2001:db8:2::/48", "2001:db8::/32", "2001:db8:5::1/128", "fe80::/10", "::1/128", "2001:db9::7/64"} {
+		prefixes = append(prefixes, netip.MustParsePrefix(prefix))
+	}
+
+	set := NewPrefixSet(prefixes)
+
+	// The nested ones are merged into 2001:db8::/32, 2001:db9::7/64 is masked
+	expected := []string{"::1/128", "2001:db8::/32", "2001:db9::/64", "fe80::/10"}
+	var merged []string
+	for _, prefix := range set.Prefixes() {
+		merged = append(merged, prefix.String())
+	}
+
+	if !slices.Equal(merged, expected) {
+		t.Fatalf("expected %v, got %v", expected, merged)
+	}
+
+	cases := map[string]bool{
+		"::":                false,
+		"::1":               true,
+		"::2":               false,
+		"2001:db7:ffff::1":  false,
+		"2001:db8::":        true,
+		"2001:db8:ffff::1":  true,
+		"2001:db9::1":       true,
+		"2001:db9:0:1::":    false,
+		"fe80::1":           true,
+		"febf:ffff::1":      true,
+		"fec0::":            false,
+		"ffff:ffff:ffff::1": false,
+	}
+
+	for address, contained := range cases {
+		if set.Contains(netip.MustParseAddr(address)) != contained {
+			t.Errorf("expected %s to be contained: %t", address, contained)
+		}
+	}
+
+	if (PrefixSet{}).Contains(netip.MustParseAddr("::1")) {
+		t.Error("expected an empty set to contain nothing")
+	}
+}
+
+func TestClipTargets(t *testing.T) {
+	targets, err := Load([]string{"1.0.0.0/24", "2.0.0.0/24", "2001:db8::1", "2001:db8::3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clipped := targets.Clip(netip.MustParseAddr("1.0.0.9"))
+	if clipped.IPv4.Size() != 10 || len(clipped.IPv6) != 2 {
+		t.Fatalf("expected 10 IPv4 and 2 IPv6 targets, got %d and %d", clipped.IPv4.Size(), len(clipped.IPv6))
+	}
+
+	clipped = targets.Clip(netip.MustParseAddr("2001:db8::2"))
+	if clipped.IPv4.Size() != 512 || len(clipped.IPv6) != 1 {
+		t.Fatalf("expected 512 IPv4 and 1 IPv6 targets, got %d and %d", clipped.IPv4.Size(), len(clipped.IPv6))
+	}
+
+	if targets.Clip(netip.MustParseAddr("0.255.255.255")).Size() != 2 {
+		t.Fatal("expected only the IPv6 targets to be left")
+	}
+}